@@ -10,6 +10,8 @@ import (
 type Node interface {
 	TokenLiteral() string
 	String() string
+	// Pos is the source position of the node's token
+	Pos() token.Position
 }
 
 // Statement is evaluated but produces no value
@@ -37,6 +39,14 @@ func (p *Program) TokenLiteral() string {
 	return ""
 }
 
+// Pos returns the position of the first statement
+func (p *Program) Pos() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[0].Pos()
+	}
+	return token.Position{}
+}
+
 func (p *Program) String() string {
 	out := StringBuilder{}
 
@@ -58,6 +68,7 @@ func (ls *LetStatement) statementNode() {}
 
 // TokenLiteral is used for debugging
 func (ls *LetStatement) TokenLiteral() string { return ls.Token.Literal }
+func (ls *LetStatement) Pos() token.Position  { return ls.Token.Pos }
 
 func (ls *LetStatement) String() string {
 	out := StringBuilder{}
@@ -85,6 +96,7 @@ func (i *Identifier) expressionNode() {}
 
 // TokenLiteral is used for debugging
 func (i *Identifier) TokenLiteral() string { return i.Token.Literal }
+func (i *Identifier) Pos() token.Position  { return i.Token.Pos }
 func (i *Identifier) String() string       { return i.Value }
 
 // ReturnStatement is "return"
@@ -97,6 +109,7 @@ func (rs *ReturnStatement) statementNode() {}
 
 // TokenLiteral is used for debugging
 func (rs *ReturnStatement) TokenLiteral() string { return rs.Token.Literal }
+func (rs *ReturnStatement) Pos() token.Position  { return rs.Token.Pos }
 
 func (rs *ReturnStatement) String() string {
 	out := StringBuilder{}
@@ -112,6 +125,81 @@ func (rs *ReturnStatement) String() string {
 	return out.String()
 }
 
+// WhileStatement runs its body for as long as its condition is truthy
+type WhileStatement struct {
+	Token     token.Token // the 'while' token
+	Condition Expression
+	Body      *BlockStatement
+}
+
+func (ws *WhileStatement) statementNode() {}
+
+// TokenLiteral is used for debugging
+func (ws *WhileStatement) TokenLiteral() string { return ws.Token.Literal }
+func (ws *WhileStatement) Pos() token.Position  { return ws.Token.Pos }
+func (ws *WhileStatement) String() string {
+	out := StringBuilder{}
+
+	out.MustWrite("while")
+	out.MustWrite(ws.Condition.String())
+	out.MustWrite(" ")
+	out.MustWrite(ws.Body.String())
+
+	return out.String()
+}
+
+// ForStatement runs its body once for each element of an array, with the
+// element bound to Name
+type ForStatement struct {
+	Token    token.Token // the 'for' token
+	Name     *Identifier
+	Iterable Expression
+	Body     *BlockStatement
+}
+
+func (fs *ForStatement) statementNode() {}
+
+// TokenLiteral is used for debugging
+func (fs *ForStatement) TokenLiteral() string { return fs.Token.Literal }
+func (fs *ForStatement) Pos() token.Position  { return fs.Token.Pos }
+func (fs *ForStatement) String() string {
+	out := StringBuilder{}
+
+	out.MustWrite("for")
+	out.MustWrite("(")
+	out.MustWrite(fs.Name.String())
+	out.MustWrite(" in ")
+	out.MustWrite(fs.Iterable.String())
+	out.MustWrite(") ")
+	out.MustWrite(fs.Body.String())
+
+	return out.String()
+}
+
+// BreakStatement leaves the innermost loop
+type BreakStatement struct {
+	Token token.Token
+}
+
+func (bs *BreakStatement) statementNode() {}
+
+// TokenLiteral is used for debugging
+func (bs *BreakStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BreakStatement) Pos() token.Position  { return bs.Token.Pos }
+func (bs *BreakStatement) String() string       { return bs.TokenLiteral() + ";" }
+
+// ContinueStatement starts the next iteration of the innermost loop
+type ContinueStatement struct {
+	Token token.Token
+}
+
+func (cs *ContinueStatement) statementNode() {}
+
+// TokenLiteral is used for debugging
+func (cs *ContinueStatement) TokenLiteral() string { return cs.Token.Literal }
+func (cs *ContinueStatement) Pos() token.Position  { return cs.Token.Pos }
+func (cs *ContinueStatement) String() string       { return cs.TokenLiteral() + ";" }
+
 // BlockStatement is a series of statements
 type BlockStatement struct {
 	Token      token.Token
@@ -122,6 +210,7 @@ func (bs *BlockStatement) statementNode() {}
 
 // TokenLiteral is used for debugging
 func (bs *BlockStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BlockStatement) Pos() token.Position  { return bs.Token.Pos }
 func (bs *BlockStatement) String() string {
 	out := StringBuilder{}
 
@@ -142,6 +231,7 @@ func (es *ExpressionStatement) statementNode() {}
 
 // TokenLiteral is used for debugging
 func (es *ExpressionStatement) TokenLiteral() string { return es.Token.Literal }
+func (es *ExpressionStatement) Pos() token.Position  { return es.Token.Pos }
 
 func (es *ExpressionStatement) String() string {
 	if es.Expression != nil {
@@ -161,6 +251,7 @@ func (pe *PrefixExpression) expressionNode() {}
 
 // TokenLiteral is used for debugging
 func (pe *PrefixExpression) TokenLiteral() string { return pe.Token.Literal }
+func (pe *PrefixExpression) Pos() token.Position  { return pe.Token.Pos }
 func (pe *PrefixExpression) String() string {
 	out := StringBuilder{}
 
@@ -184,6 +275,7 @@ func (ie *InfixExpression) expressionNode() {}
 
 // TokenLiteral is used for debugging
 func (ie *InfixExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *InfixExpression) Pos() token.Position  { return ie.Token.Pos }
 func (ie *InfixExpression) String() string {
 	out := StringBuilder{}
 
@@ -208,6 +300,7 @@ func (ie *IfExpression) expressionNode() {}
 
 // TokenLiteral is used for debugging
 func (ie *IfExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IfExpression) Pos() token.Position  { return ie.Token.Pos }
 func (ie *IfExpression) String() string {
 	out := StringBuilder{}
 
@@ -235,6 +328,7 @@ func (ce *CallExpression) expressionNode() {}
 
 // TokenLiteral is used for debugging
 func (ce *CallExpression) TokenLiteral() string { return ce.Token.Literal }
+func (ce *CallExpression) Pos() token.Position  { return ce.Token.Pos }
 func (ce *CallExpression) String() string {
 	out := StringBuilder{}
 
@@ -261,11 +355,26 @@ func (il *IntegerLiteral) expressionNode() {}
 
 // TokenLiteral is used for debugging
 func (il *IntegerLiteral) TokenLiteral() string { return il.Token.Literal }
+func (il *IntegerLiteral) Pos() token.Position  { return il.Token.Pos }
 func (il *IntegerLiteral) String() string       { return il.Token.Literal }
 
+// FloatLiteral is a floating-point number
+type FloatLiteral struct {
+	Token token.Token
+	Value float64
+}
+
+func (fl *FloatLiteral) expressionNode() {}
+
+// TokenLiteral is used for debugging
+func (fl *FloatLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FloatLiteral) Pos() token.Position  { return fl.Token.Pos }
+func (fl *FloatLiteral) String() string       { return fl.Token.Literal }
+
 // FunctionLiteral is fn
 type FunctionLiteral struct {
 	Token      token.Token
+	Name       string // set when the function is bound by a let statement
 	Parameters []*Identifier
 	Body       *BlockStatement
 }
@@ -274,6 +383,7 @@ func (fl *FunctionLiteral) expressionNode() {}
 
 // TokenLiteral is used for debugging
 func (fl *FunctionLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FunctionLiteral) Pos() token.Position  { return fl.Token.Pos }
 func (fl *FunctionLiteral) String() string {
 	out := StringBuilder{}
 
@@ -301,6 +411,7 @@ func (sl *StringLiteral) expressionNode() {}
 
 // TokenLiteral is used for debugging
 func (sl *StringLiteral) TokenLiteral() string { return sl.Token.Literal }
+func (sl *StringLiteral) Pos() token.Position  { return sl.Token.Pos }
 func (sl *StringLiteral) String() string       { return sl.Token.Literal }
 
 // ArrayLiteral is an array
@@ -313,6 +424,7 @@ func (al *ArrayLiteral) expressionNode() {}
 
 // TokenLiteral is used for debugging
 func (al *ArrayLiteral) TokenLiteral() string { return al.Token.Literal }
+func (al *ArrayLiteral) Pos() token.Position  { return al.Token.Pos }
 func (al *ArrayLiteral) String() string {
 	out := StringBuilder{}
 
@@ -338,6 +450,7 @@ func (hl *HashLiteral) expressionNode() {}
 
 // TokenLiteral is used for debugging
 func (hl *HashLiteral) TokenLiteral() string { return hl.Token.Literal }
+func (hl *HashLiteral) Pos() token.Position  { return hl.Token.Pos }
 func (hl *HashLiteral) String() string {
 	out := StringBuilder{}
 
@@ -364,6 +477,7 @@ func (ie *IndexExpression) expressionNode() {}
 
 // TokenLiteral is used for debugging
 func (ie *IndexExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IndexExpression) Pos() token.Position  { return ie.Token.Pos }
 func (ie *IndexExpression) String() string {
 	out := StringBuilder{}
 
@@ -376,6 +490,31 @@ func (ie *IndexExpression) String() string {
 	return out.String()
 }
 
+// AssignExpression rebinds an existing variable, or stores into an index of
+// an array or hash
+type AssignExpression struct {
+	Token  token.Token // the = token
+	Target Expression  // an *Identifier or *IndexExpression
+	Value  Expression
+}
+
+func (ae *AssignExpression) expressionNode() {}
+
+// TokenLiteral is used for debugging
+func (ae *AssignExpression) TokenLiteral() string { return ae.Token.Literal }
+func (ae *AssignExpression) Pos() token.Position  { return ae.Token.Pos }
+func (ae *AssignExpression) String() string {
+	out := StringBuilder{}
+
+	out.MustWrite("(")
+	out.MustWrite(ae.Target.String())
+	out.MustWrite(" = ")
+	out.MustWrite(ae.Value.String())
+	out.MustWrite(")")
+
+	return out.String()
+}
+
 // Boolean is a bool
 type Boolean struct {
 	Token token.Token
@@ -386,6 +525,7 @@ func (b *Boolean) expressionNode() {}
 
 // TokenLiteral is used for debugging
 func (b *Boolean) TokenLiteral() string { return b.Token.Literal }
+func (b *Boolean) Pos() token.Position  { return b.Token.Pos }
 func (b *Boolean) String() string       { return b.Token.Literal }
 
 // MacroLiteral is a macro
@@ -399,6 +539,7 @@ func (m *MacroLiteral) expressionNode() {}
 
 // TokenLiteral is used for debugging
 func (m *MacroLiteral) TokenLiteral() string { return m.Token.Literal }
+func (m *MacroLiteral) Pos() token.Position  { return m.Token.Pos }
 func (m *MacroLiteral) String() string {
 	out := StringBuilder{}
 
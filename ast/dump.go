@@ -0,0 +1,80 @@
+package ast
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+var nodeType = reflect.TypeOf((*Node)(nil)).Elem()
+
+// Dump returns node and its descendants as an indented tree, one node per
+// line, each with its fields and source position
+func Dump(node Node) string {
+	out := StringBuilder{}
+	dumpNode(&out, "", node, 0)
+	return out.String()
+}
+
+func dumpNode(out *StringBuilder, label string, node Node, depth int) {
+	out.MustWrite(strings.Repeat("  ", depth) + label)
+
+	v := reflect.ValueOf(node)
+	if node == nil || v.Kind() == reflect.Ptr && v.IsNil() {
+		out.MustWrite("nil\n")
+		return
+	}
+
+	elem := reflect.Indirect(v)
+	out.MustWrite(elem.Type().Name())
+
+	type child struct {
+		label string
+		node  Node
+	}
+	children := []child{}
+
+	for i := 0; i < elem.NumField(); i++ {
+		field := elem.Type().Field(i)
+		value := elem.Field(i)
+
+		switch {
+		case field.Name == "Token":
+			continue
+		case value.Type().Implements(nodeType):
+			if !value.IsNil() {
+				children = append(children, child{field.Name + ": ", value.Interface().(Node)})
+			}
+		case value.Kind() == reflect.Slice && value.Type().Elem().Implements(nodeType):
+			for j := 0; j < value.Len(); j++ {
+				label := fmt.Sprintf("%s[%d]: ", field.Name, j)
+				children = append(children, child{label, value.Index(j).Interface().(Node)})
+			}
+		case value.Kind() == reflect.Map:
+			keys := value.MapKeys()
+			sort.Slice(keys, func(i, j int) bool {
+				return keys[i].Interface().(Node).String() < keys[j].Interface().(Node).String()
+			})
+			for _, k := range keys {
+				children = append(children,
+					child{field.Name + " key: ", k.Interface().(Node)},
+					child{field.Name + " value: ", value.MapIndex(k).Interface().(Node)},
+				)
+			}
+		case value.Kind() == reflect.String:
+			out.MustWrite(fmt.Sprintf(" %s=%q", field.Name, value.String()))
+		default:
+			out.MustWrite(fmt.Sprintf(" %s=%v", field.Name, value.Interface()))
+		}
+	}
+
+	if pos := node.Pos(); pos.IsValid() {
+		out.MustWrite(" @" + pos.String())
+	}
+	out.MustWrite("\n")
+
+	for _, c := range children {
+		dumpNode(out, c.label, c.node, depth+1)
+	}
+}
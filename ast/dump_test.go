@@ -0,0 +1,46 @@
+package ast
+
+import (
+	"testing"
+
+	"github.com/mikeraimondi/monkey/token"
+)
+
+func TestDump(t *testing.T) {
+	pos := func(col int) token.Position { return token.Position{Line: 1, Column: col} }
+
+	program := &Program{
+		Statements: []Statement{
+			&LetStatement{
+				Token: token.Token{Type: token.LET, Literal: "let", Pos: pos(1)},
+				Name: &Identifier{
+					Token: token.Token{Type: token.IDENT, Literal: "x", Pos: pos(5)},
+					Value: "x",
+				},
+				Value: &InfixExpression{
+					Token: token.Token{Type: token.PLUS, Literal: "+", Pos: pos(11)},
+					Left: &IntegerLiteral{
+						Token: token.Token{Type: token.INT, Literal: "1", Pos: pos(9)},
+						Value: 1,
+					},
+					Operator: "+",
+					Right: &Boolean{
+						Token: token.Token{Type: token.TRUE, Literal: "true", Pos: pos(13)},
+						Value: true,
+					},
+				},
+			},
+		},
+	}
+
+	expected := `Program @1:1
+  Statements[0]: LetStatement @1:1
+    Name: Identifier Value="x" @1:5
+    Value: InfixExpression Operator="+" @1:11
+      Left: IntegerLiteral Value=1 @1:9
+      Right: Boolean Value=true @1:13
+`
+	if actual := Dump(program); actual != expected {
+		t.Errorf("wrong dump.\nexpected:\n%s\ngot:\n%s", expected, actual)
+	}
+}
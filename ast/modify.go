@@ -17,6 +17,10 @@ func Modify(node Node, modifier ModifierFunc) Node {
 	case *PrefixExpression:
 		// TODO error handling
 		node.Right, _ = Modify(node.Right, modifier).(Expression)
+	case *AssignExpression:
+		// TODO error handling
+		node.Target, _ = Modify(node.Target, modifier).(Expression)
+		node.Value, _ = Modify(node.Value, modifier).(Expression)
 	case *IndexExpression:
 		// TODO error handling
 		node.Left, _ = Modify(node.Left, modifier).(Expression)
@@ -30,6 +34,14 @@ func Modify(node Node, modifier ModifierFunc) Node {
 		}
 	case *BlockStatement:
 		modifyStatements(node.Statements, modifier)
+	case *WhileStatement:
+		// TODO error handling
+		node.Condition, _ = Modify(node.Condition, modifier).(Expression)
+		node.Body, _ = Modify(node.Body, modifier).(*BlockStatement)
+	case *ForStatement:
+		// TODO error handling
+		node.Iterable, _ = Modify(node.Iterable, modifier).(Expression)
+		node.Body, _ = Modify(node.Body, modifier).(*BlockStatement)
 	case *ReturnStatement:
 		// TODO error handling
 		node.ReturnValue, _ = Modify(node.ReturnValue, modifier).(Expression)
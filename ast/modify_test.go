@@ -59,6 +59,42 @@ func TestModify(t *testing.T) {
 			&IndexExpression{Left: one(), Index: one()},
 			&IndexExpression{Left: two(), Index: two()},
 		},
+		{
+			&WhileStatement{
+				Condition: one(),
+				Body: &BlockStatement{
+					Statements: []Statement{
+						&ExpressionStatement{Expression: one()},
+					},
+				},
+			},
+			&WhileStatement{
+				Condition: two(),
+				Body: &BlockStatement{
+					Statements: []Statement{
+						&ExpressionStatement{Expression: two()},
+					},
+				},
+			},
+		},
+		{
+			&ForStatement{
+				Iterable: one(),
+				Body: &BlockStatement{
+					Statements: []Statement{
+						&ExpressionStatement{Expression: one()},
+					},
+				},
+			},
+			&ForStatement{
+				Iterable: two(),
+				Body: &BlockStatement{
+					Statements: []Statement{
+						&ExpressionStatement{Expression: two()},
+					},
+				},
+			},
+		},
 		{
 			&IfExpression{
 				Condition: one(),
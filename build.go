@@ -0,0 +1,111 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mikeraimondi/monkey/compiler"
+	"github.com/mikeraimondi/monkey/engine"
+	"github.com/mikeraimondi/monkey/object"
+)
+
+const buildUsage = `usage: monkey build [-O] [-o output] file
+
+Build compiles the program in file to bytecode, written to the file's name
+with a .mkc extension unless -o is given. monkey runs .mkc files like
+source files, skipping compilation. With -O, the bytecode is optimized.
+
+Flags:
+`
+
+// buildCommand implements "monkey build" with the arguments following it
+func buildCommand(args []string) int {
+	flags := flag.NewFlagSet("build", flag.ContinueOnError)
+	output := flags.String("o", "", "write the bytecode to `file`")
+	optimize := flags.Bool("O", false, "optimize the bytecode")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), buildUsage)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	name := flags.Arg(0)
+	src, err := ioutil.ReadFile(name)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+
+	var options []compiler.Option
+	if *optimize {
+		options = append(options, compiler.WithOptimization())
+	}
+	data, ok := build(name, string(src), os.Stderr, options...)
+	if !ok {
+		return exitFailure
+	}
+
+	out := *output
+	if out == "" {
+		out = strings.TrimSuffix(name, filepath.Ext(name)) + ".mkc"
+	}
+	if err := ioutil.WriteFile(out, data, 0644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+	return exitOK
+}
+
+// build compiles the program in source, read from the file name, to
+// serialized bytecode. Errors are written to stderr.
+func build(name, source string, stderr io.Writer, options ...compiler.Option) ([]byte, bool) {
+	code, _, ok := compile(name, source, stderr, options...)
+	if !ok {
+		return nil, false
+	}
+
+	data, err := code.MarshalBinary()
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return nil, false
+	}
+	return data, true
+}
+
+// compile compiles the program in source, read from the file name, to
+// bytecode that can be run with args defined. It also returns the global
+// symbols. Errors are written to stderr.
+func compile(
+	name, source string,
+	stderr io.Writer,
+	options ...compiler.Option,
+) (*compiler.Bytecode, *compiler.SymbolTable, bool) {
+	program, ok := parse(name, source, stderr)
+	if !ok {
+		return nil, nil, false
+	}
+
+	// args is defined when the bytecode is run, so it must have the same
+	// global index
+	eng := engine.NewVM()
+	eng.Define("args", &object.Array{})
+
+	comp := compiler.NewWithState(eng.SymbolTable, eng.Constants, options...)
+	err := comp.Compile(program)
+	if err != nil {
+		fmt.Fprintln(stderr, &engine.Error{Phase: engine.Compilation, Err: err})
+		return nil, nil, false
+	}
+	return comp.Bytecode(), eng.SymbolTable, true
+}
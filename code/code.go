@@ -36,6 +36,27 @@ const (
 	OpReturnValue
 	OpReturn
 	OpClosure
+	OpMod
+	OpGreaterThanOrEqual
+	OpJumpNotTruthyOrPop
+	OpJumpTruthyOrPop
+	OpIterInit
+	OpIterNext
+	OpSetFree
+	OpSetIndex
+	OpCaptureLocal
+	OpCaptureFree
+	OpCurrentClosure
+	OpTailCall
+	OpGetLocal0
+	OpGetLocal1
+	OpGetLocal2
+	OpGetLocal3
+	OpAddConst
+	OpCompareJump
+	OpIncrLocal
+	OpCompareConstJump
+	OpSubConst
 )
 
 var definitions = map[Opcode]*Definition{
@@ -68,6 +89,53 @@ var definitions = map[Opcode]*Definition{
 	OpReturnValue:   {"OpReturnValue", []int{}},
 	OpReturn:        {"OpReturn", []int{}},
 	OpClosure:       {"OpClosure", []int{2, 1}},
+	OpMod:           {"OpMod", []int{}},
+
+	OpGreaterThanOrEqual: {"OpGreaterThanOrEqual", []int{}},
+	// the OrPop jumps leave the condition on the stack if they jump, and
+	// pop it if they don't
+	OpJumpNotTruthyOrPop: {"OpJumpNotTruthyOrPop", []int{2}},
+	OpJumpTruthyOrPop:    {"OpJumpTruthyOrPop", []int{2}},
+	// OpIterInit pushes the index 0 after the array on top of the stack.
+	// OpIterNext pushes the array's element at the index and increments it,
+	// or pops both and jumps once the array is exhausted.
+	OpIterInit: {"OpIterInit", []int{}},
+	OpIterNext: {"OpIterNext", []int{2}},
+	OpSetFree:  {"OpSetFree", []int{1}},
+	// OpSetIndex stores the value on top of the stack into the array or hash
+	// below the index under it, leaving the value on the stack
+	OpSetIndex: {"OpSetIndex", []int{}},
+	// the capture opcodes push the cell of a variable, for OpClosure to
+	// share with the closure it creates
+	OpCaptureLocal: {"OpCaptureLocal", []int{1}},
+	OpCaptureFree:  {"OpCaptureFree", []int{1}},
+
+	// OpCurrentClosure pushes the closure being executed, so that functions
+	// can call themselves
+	OpCurrentClosure: {"OpCurrentClosure", []int{}},
+
+	// OpTailCall is a call whose result is returned by the calling function,
+	// which reuses the caller's frame
+	OpTailCall: {"OpTailCall", []int{1}},
+
+	// the following are superinstructions the compiler replaces common
+	// sequences with. OpGetLocal0 to 3 are OpGetLocal with their operand.
+	OpGetLocal0: {"OpGetLocal0", []int{}},
+	OpGetLocal1: {"OpGetLocal1", []int{}},
+	OpGetLocal2: {"OpGetLocal2", []int{}},
+	OpGetLocal3: {"OpGetLocal3", []int{}},
+	// OpAddConst adds a constant to the value on top of the stack
+	OpAddConst: {"OpAddConst", []int{2}},
+	// OpCompareJump compares the top two values of the stack with its
+	// second operand, a comparison opcode, and jumps if they don't compare
+	OpCompareJump: {"OpCompareJump", []int{2, 1}},
+	// OpIncrLocal adds a constant to a local
+	OpIncrLocal: {"OpIncrLocal", []int{1, 2}},
+	// OpCompareConstJump is OpCompareJump with its right operand the
+	// constant in its second operand rather than on the stack
+	OpCompareConstJump: {"OpCompareConstJump", []int{2, 2, 1}},
+	// OpSubConst subtracts a constant from the value on top of the stack
+	OpSubConst: {"OpSubConst", []int{2}},
 }
 
 type Definition struct {
@@ -75,6 +143,26 @@ type Definition struct {
 	OperandWidths []int
 }
 
+// Width returns the number of bytes taken by the operands
+func (def *Definition) Width() int {
+	width := 0
+	for _, w := range def.OperandWidths {
+		width += w
+	}
+	return width
+}
+
+// IsJump reports whether op's first operand is the offset of an instruction
+// it may jump to
+func IsJump(op Opcode) bool {
+	switch op {
+	case OpJump, OpJumpNotTruthy, OpJumpNotTruthyOrPop, OpJumpTruthyOrPop, OpIterNext,
+		OpCompareJump, OpCompareConstJump:
+		return true
+	}
+	return false
+}
+
 func Lookup(op byte) (*Definition, error) {
 	def, ok := definitions[Opcode(op)]
 	if !ok {
@@ -93,8 +181,13 @@ func (ins Instructions) String() string {
 		def, err := Lookup(ins[i])
 		if err != nil {
 			fmt.Fprintf(&out, "ERROR: %s\n", err)
+			i++
 			continue
 		}
+		if i+1+def.Width() > len(ins) {
+			fmt.Fprintf(&out, "ERROR: truncated %s\n", def.Name)
+			break
+		}
 
 		operands, read := ReadOperands(def, ins[i+1:])
 		fmt.Fprintf(&out, "%04d %s\n", i, ins.fmtInstruction(def, operands))
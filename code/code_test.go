@@ -12,6 +12,7 @@ func TestMake(t *testing.T) {
 		{OpAdd, []int{}, []byte{byte(OpAdd)}},
 		{OpGetLocal, []int{255}, []byte{byte(OpGetLocal), 255}},
 		{OpClosure, []int{65534, 255}, []byte{byte(OpClosure), 255, 254, 255}},
+		{OpIncrLocal, []int{255, 65534}, []byte{byte(OpIncrLocal), 255, 255, 254}},
 	}
 	for _, tt := range tests {
 		instruction := Make(tt.op, tt.operands...)
@@ -53,6 +54,29 @@ func TestInstructionsString(t *testing.T) {
 	}
 }
 
+func TestInstructionsStringInvalid(t *testing.T) {
+	tests := []struct {
+		ins      Instructions
+		expected string
+	}{
+		{
+			Instructions{255, byte(OpAdd)},
+			"ERROR: opcode 255 undefinied\n0001 OpAdd\n",
+		},
+		{
+			append(Make(OpAdd), Make(OpConstant, 1)[:2]...),
+			"0000 OpAdd\nERROR: truncated OpConstant\n",
+		},
+	}
+
+	for _, tt := range tests {
+		if actual := tt.ins.String(); actual != tt.expected {
+			t.Errorf("instructions wrongly formatted.\nexpected %q\ngot %q",
+				tt.expected, actual)
+		}
+	}
+}
+
 func TestReadOperands(t *testing.T) {
 	tests := []struct {
 		op        Opcode
@@ -62,6 +86,9 @@ func TestReadOperands(t *testing.T) {
 		{OpConstant, []int{65535}, 2},
 		{OpGetLocal, []int{255}, 1},
 		{OpClosure, []int{65535, 255}, 3},
+		{OpCompareJump, []int{65535, 255}, 3},
+		{OpIncrLocal, []int{255, 65535}, 3},
+		{OpCompareConstJump, []int{65535, 65534, 255}, 5},
 	}
 	for _, tt := range tests {
 		instruction := Make(tt.op, tt.operands...)
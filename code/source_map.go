@@ -0,0 +1,27 @@
+package code
+
+import (
+	"sort"
+
+	"github.com/mikeraimondi/monkey/token"
+)
+
+// SourcePosition associates an instruction offset with the position of the
+// source it was compiled from
+type SourcePosition struct {
+	Offset int
+	Pos    token.Position
+}
+
+// SourceMap is a list of SourcePositions, ordered by Offset. An entry covers
+// every instruction up to the next entry's Offset.
+type SourceMap []SourcePosition
+
+// Lookup returns the source position of the instruction at offset
+func (sm SourceMap) Lookup(offset int) (token.Position, bool) {
+	i := sort.Search(len(sm), func(i int) bool { return sm[i].Offset > offset })
+	if i == 0 {
+		return token.Position{}, false
+	}
+	return sm[i-1].Pos, true
+}
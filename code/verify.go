@@ -0,0 +1,36 @@
+package code
+
+import "fmt"
+
+// Verify checks that ins is a sequence of complete instructions with known
+// opcodes, and that its jumps land on an instruction or just past the last
+func Verify(ins Instructions) error {
+	starts := make([]bool, len(ins)+1)
+	var jumps []int
+
+	for ip := 0; ip < len(ins); {
+		def, err := Lookup(ins[ip])
+		if err != nil {
+			return fmt.Errorf("%s at %d", err, ip)
+		}
+		if ip+1+def.Width() > len(ins) {
+			return fmt.Errorf("truncated %s at %d", def.Name, ip)
+		}
+
+		starts[ip] = true
+		if IsJump(Opcode(ins[ip])) {
+			jumps = append(jumps, ip)
+		}
+		ip += 1 + def.Width()
+	}
+	starts[len(ins)] = true
+
+	for _, ip := range jumps {
+		target := int(ReadUint16(ins[ip+1:]))
+		if target >= len(starts) || !starts[target] {
+			return fmt.Errorf("jump at %d to %d, which is not an instruction", ip, target)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,35 @@
+package code
+
+import "testing"
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		ins      []Instructions
+		expected string
+	}{
+		{[]Instructions{Make(OpTrue), Make(OpJumpNotTruthy, 5), Make(OpNull), Make(OpPop)}, ""},
+		{[]Instructions{Make(OpJump, 3)}, ""},
+		{[]Instructions{Make(OpAdd), {255}}, "opcode 255 undefinied at 1"},
+		{[]Instructions{Make(OpAdd), Make(OpConstant, 1)[:2]}, "truncated OpConstant at 1"},
+		{[]Instructions{Make(OpJump, 4)}, "jump at 0 to 4, which is not an instruction"},
+		{[]Instructions{Make(OpConstant, 0), Make(OpJump, 1)}, "jump at 3 to 1, which is not an instruction"},
+	}
+
+	for _, tt := range tests {
+		ins := Instructions{}
+		for _, i := range tt.ins {
+			ins = append(ins, i...)
+		}
+
+		err := Verify(ins)
+		if tt.expected == "" {
+			if err != nil {
+				t.Errorf("unexpected error for %q: %s", ins, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong error for %q. expected %q, got %v", ins, tt.expected, err)
+		}
+	}
+}
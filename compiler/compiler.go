@@ -7,6 +7,7 @@ import (
 	"github.com/mikeraimondi/monkey/ast"
 	"github.com/mikeraimondi/monkey/code"
 	"github.com/mikeraimondi/monkey/object"
+	"github.com/mikeraimondi/monkey/token"
 )
 
 type EmittedInstruction struct {
@@ -16,8 +17,26 @@ type EmittedInstruction struct {
 
 type CompilationScope struct {
 	instructions        code.Instructions
+	sourceMap           code.SourceMap
 	lastInstruction     EmittedInstruction
 	previousInstruction EmittedInstruction
+	loops               []*Loop
+	// operands is the number of values left on the stack by the expressions
+	// being compiled, to be used by the operations enclosing them
+	operands int
+}
+
+// Loop tracks the jumps out of a loop being compiled
+type Loop struct {
+	// Start is where continue jumps to
+	Start int
+	// Iterator is whether the loop keeps an array and index on the stack
+	Iterator bool
+	// Operands is the number of operands on the stack when the loop is
+	// entered. Breaks and continues pop any pushed since.
+	Operands int
+	// Breaks are the positions of jumps to be patched to the loop's end
+	Breaks []int
 }
 
 type Compiler struct {
@@ -25,9 +44,29 @@ type Compiler struct {
 	symbolTable *SymbolTable
 	scopes      []CompilationScope
 	scopeIndex  int
+
+	// constantIndex maps the values of integer and string constants to
+	// their index, so that equal ones are only added once
+	constantIndex map[interface{}]int
+
+	// pos is the source position of the node currently being compiled
+	pos token.Position
+
+	// optimizing is whether the instructions of each scope are optimized
+	optimizing bool
 }
 
-func New() *Compiler {
+// Option configures a Compiler
+type Option func(*Compiler)
+
+// WithOptimization makes the compiler compute expressions of literals while
+// compiling, run a peephole optimizer over the instructions of the program
+// and each function, and fuse comparisons against constants with their jumps
+func WithOptimization() Option {
+	return func(c *Compiler) { c.optimizing = true }
+}
+
+func New(options ...Option) *Compiler {
 	mainScope := CompilationScope{
 		instructions:        code.Instructions{},
 		lastInstruction:     EmittedInstruction{},
@@ -39,22 +78,42 @@ func New() *Compiler {
 		symbolTable.DefineBuiltin(i, v.Name)
 	}
 
-	return &Compiler{
-		constants:   []object.Object{},
-		symbolTable: symbolTable,
-		scopes:      []CompilationScope{mainScope},
-		scopeIndex:  0,
+	c := &Compiler{
+		constants:     []object.Object{},
+		constantIndex: map[interface{}]int{},
+		symbolTable:   symbolTable,
+		scopes:        []CompilationScope{mainScope},
+		scopeIndex:    0,
 	}
+	for _, option := range options {
+		option(c)
+	}
+	return c
 }
 
-func NewWithState(s *SymbolTable, constants []object.Object) *Compiler {
-	compiler := New()
+func NewWithState(s *SymbolTable, constants []object.Object, options ...Option) *Compiler {
+	compiler := New(options...)
 	compiler.symbolTable = s
 	compiler.constants = constants
+	for i, c := range constants {
+		if key, ok := constantKey(c); ok {
+			if _, ok := compiler.constantIndex[key]; !ok {
+				compiler.constantIndex[key] = i
+			}
+		}
+	}
 	return compiler
 }
 
 func (c *Compiler) Compile(node ast.Node) error {
+	if node != nil {
+		if pos := node.Pos(); pos.IsValid() {
+			outer := c.pos
+			c.pos = pos
+			defer func() { c.pos = outer }()
+		}
+	}
+
 	switch node := node.(type) {
 	case *ast.Program:
 		for _, s := range node.Statements {
@@ -63,22 +122,79 @@ func (c *Compiler) Compile(node ast.Node) error {
 				return err
 			}
 		}
+		c.optimize(true)
 	case *ast.LetStatement:
-		symbol := c.symbolTable.Define(node.Name.Value)
+		// the value is compiled first so that it refers to any outer variable
+		// of the same name. local functions refer to themselves through
+		// FunctionScope, while global ones look up their global when called,
+		// as it may be reassigned, so it is defined first
+		_, isFunction := node.Value.(*ast.FunctionLiteral)
+		definedFirst := isFunction && c.symbolTable.Outer == nil
+		var symbol Symbol
+		if definedFirst {
+			symbol = c.symbolTable.Define(node.Name.Value)
+		}
 		err := c.Compile(node.Value)
 		if err != nil {
 			return err
 		}
-
-		if symbol.Scope == GlobalScope {
-			c.emit(code.OpSetGlobal, symbol.Index)
-		} else {
-			c.emit(code.OpSetLocal, symbol.Index)
+		if !definedFirst {
+			symbol = c.symbolTable.Define(node.Name.Value)
 		}
+		c.storeSymbol(symbol)
+	case *ast.WhileStatement:
+		loop := c.enterLoop(false)
+		err := c.Compile(node.Condition)
+		if err != nil {
+			return err
+		}
+		jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999) // use bogus offset
+		err = c.Compile(node.Body)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpJump, loop.Start)
+		c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+		c.leaveLoop()
+	case *ast.ForStatement:
+		err := c.Compile(node.Iterable)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpIterInit)
+		symbol := c.symbolTable.Define(node.Name.Value)
+		loop := c.enterLoop(true)
+		iterNextPos := c.emit(code.OpIterNext, 9999) // use bogus offset
+		c.storeSymbol(symbol)
+		err = c.Compile(node.Body)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpJump, loop.Start)
+		c.changeOperand(iterNextPos, len(c.currentInstructions()))
+		c.leaveLoop()
+	case *ast.BreakStatement:
+		loop := c.currentLoop()
+		if loop == nil {
+			return c.errorf("break outside loop")
+		}
+		c.popOperands(loop)
+		if loop.Iterator {
+			c.emit(code.OpPop)
+			c.emit(code.OpPop)
+		}
+		loop.Breaks = append(loop.Breaks, c.emit(code.OpJump, 9999)) // use bogus offset
+	case *ast.ContinueStatement:
+		loop := c.currentLoop()
+		if loop == nil {
+			return c.errorf("continue outside loop")
+		}
+		c.popOperands(loop)
+		c.emit(code.OpJump, loop.Start)
 	case *ast.Identifier:
 		symbol, ok := c.symbolTable.Resolve(node.Value)
 		if !ok {
-			return fmt.Errorf("undefined variable %s", node.Value)
+			return c.errorf("undefined variable %s", node.Value)
 		}
 		c.loadSymbol(symbol)
 	case *ast.ExpressionStatement:
@@ -95,6 +211,9 @@ func (c *Compiler) Compile(node ast.Node) error {
 			}
 		}
 	case *ast.PrefixExpression:
+		if c.optimizing && c.emitFolded(node) {
+			return nil
+		}
 		err := c.Compile(node.Right)
 		if err != nil {
 			return err
@@ -105,26 +224,42 @@ func (c *Compiler) Compile(node ast.Node) error {
 		case "-":
 			c.emit(code.OpMinus)
 		default:
-			return fmt.Errorf("unknown operator %s", node.Operator)
+			return c.errorf("unknown operator %s", node.Operator)
 		}
 	case *ast.InfixExpression:
-		if node.Operator == "<" {
-			err := c.Compile(node.Right)
+		if c.optimizing && c.emitFolded(node) {
+			return nil
+		}
+		if node.Operator == "&&" || node.Operator == "||" {
+			err := c.Compile(node.Left)
 			if err != nil {
 				return err
 			}
-			err = c.Compile(node.Left)
+			jumpOp := code.OpJumpNotTruthyOrPop
+			if node.Operator == "||" {
+				jumpOp = code.OpJumpTruthyOrPop
+			}
+			jumpPos := c.emit(jumpOp, 9999) // use bogus offset
+			err = c.Compile(node.Right)
 			if err != nil {
 				return err
 			}
-			c.emit(code.OpGreaterThan)
+			c.changeOperand(jumpPos, len(c.currentInstructions()))
 			return nil
 		}
-		err := c.Compile(node.Left)
-		if err != nil {
-			return err
+		if node.Operator == "<" || node.Operator == "<=" {
+			err := c.compileOperands(node.Right, node.Left)
+			if err != nil {
+				return err
+			}
+			if node.Operator == "<" {
+				c.emit(code.OpGreaterThan)
+			} else {
+				c.emit(code.OpGreaterThanOrEqual)
+			}
+			return nil
 		}
-		err = c.Compile(node.Right)
+		err := c.compileOperands(node.Left, node.Right)
 		if err != nil {
 			return err
 		}
@@ -137,14 +272,18 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(code.OpMul)
 		case "/":
 			c.emit(code.OpDiv)
+		case "%":
+			c.emit(code.OpMod)
 		case ">":
 			c.emit(code.OpGreaterThan)
+		case ">=":
+			c.emit(code.OpGreaterThanOrEqual)
 		case "==":
 			c.emit(code.OpEqual)
 		case "!=":
 			c.emit(code.OpNotEqual)
 		default:
-			return fmt.Errorf("unknown operator %s", node.Operator)
+			return c.errorf("unknown operator %s", node.Operator)
 		}
 	case *ast.IfExpression:
 		err := c.Compile(node.Condition)
@@ -156,9 +295,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 		if err != nil {
 			return err
 		}
-		if c.lastInstructionIs(code.OpPop) {
-			c.removeLastPop()
-		}
+		c.leaveBlockValue()
 		jumpPos := c.emit(code.OpJump, 9999) // use bogus offset
 		afterConsequencePos := len(c.currentInstructions())
 		c.changeOperand(jumpNotTruthyPos, afterConsequencePos)
@@ -169,14 +306,16 @@ func (c *Compiler) Compile(node ast.Node) error {
 			if err != nil {
 				return err
 			}
-			if c.lastInstructionIs(code.OpPop) {
-				c.removeLastPop()
-			}
+			c.leaveBlockValue()
 		}
 		afterAlternativePos := len(c.currentInstructions())
 		c.changeOperand(jumpPos, afterAlternativePos)
 	case *ast.FunctionLiteral:
+		isLocal := c.symbolTable.Outer != nil
 		c.enterScope()
+		if node.Name != "" && isLocal {
+			c.symbolTable.DefineFunctionName(node.Name)
+		}
 		for _, p := range node.Parameters {
 			c.symbolTable.Define(p.Value)
 		}
@@ -192,51 +331,91 @@ func (c *Compiler) Compile(node ast.Node) error {
 		if !c.lastInstructionIs(code.OpReturnValue) {
 			c.emit(code.OpReturn)
 		}
+		c.optimize(false)
+		markTailCalls(c.currentInstructions())
 
 		freeSymbols := c.symbolTable.FreeSymbols
 		numLocals := c.symbolTable.numDefinitions
+		locals := c.symbolTable.Names()
+		sourceMap := c.scopes[c.scopeIndex].sourceMap
 		instructions := c.leaveScope()
 
 		for _, s := range freeSymbols {
-			c.loadSymbol(s)
+			c.captureSymbol(s)
+		}
+
+		params := make([]string, len(node.Parameters))
+		for i, p := range node.Parameters {
+			params[i] = p.Value
+		}
+		free := make([]string, len(freeSymbols))
+		for i, s := range freeSymbols {
+			free[i] = s.Name
 		}
 
 		compiledFn := &object.CompiledFunction{
+			Name:          node.Name,
+			Parameters:    params,
+			Locals:        locals,
+			Free:          free,
 			Instructions:  instructions,
 			NumLocals:     numLocals,
 			NumParameters: len(node.Parameters),
+			SourceMap:     sourceMap,
 		}
 
 		fnIndex := c.addConstant(compiledFn)
 		c.emit(code.OpClosure, fnIndex, len(freeSymbols))
 	case *ast.ReturnStatement:
+		if c.scopeIndex == 0 {
+			return c.errorf("return outside function")
+		}
 		err := c.Compile(node.ReturnValue)
 		if err != nil {
 			return err
 		}
 		c.emit(code.OpReturnValue)
 	case *ast.CallExpression:
-		err := c.Compile(node.Function)
+		operands := append([]ast.Expression{node.Function}, node.Arguments...)
+		err := c.compileOperands(operands...)
 		if err != nil {
 			return err
 		}
-		for _, a := range node.Arguments {
-			err := c.Compile(a)
-			if err != nil {
-				return err
-			}
-		}
 		c.emit(code.OpCall, len(node.Arguments))
 	case *ast.IndexExpression:
-		err := c.Compile(node.Left)
-		if err != nil {
-			return err
-		}
-		err = c.Compile(node.Index)
+		err := c.compileOperands(node.Left, node.Index)
 		if err != nil {
 			return err
 		}
 		c.emit(code.OpIndex)
+	case *ast.AssignExpression:
+		switch target := node.Target.(type) {
+		case *ast.Identifier:
+			symbol, ok := c.symbolTable.Resolve(target.Value)
+			if !ok {
+				return c.errorf("undefined variable %s", target.Value)
+			}
+			if symbol.Scope == BuiltinScope {
+				return c.errorf("cannot assign to builtin %s", target.Value)
+			}
+			if symbol.Scope == FunctionScope {
+				return c.errorf("cannot assign to %s inside its own definition", target.Value)
+			}
+			err := c.Compile(node.Value)
+			if err != nil {
+				return err
+			}
+			c.storeSymbol(symbol)
+			c.loadSymbol(symbol)
+		case *ast.IndexExpression:
+			err := c.compileOperands(target.Left, target.Index, node.Value)
+			if err != nil {
+				return err
+			}
+			c.emit(code.OpSetIndex)
+		default:
+			return c.errorf("cannot assign to %s", node.Target.String())
+		}
 	case *ast.HashLiteral:
 		keys := []ast.Expression{}
 		for k := range node.Pairs {
@@ -245,23 +424,19 @@ func (c *Compiler) Compile(node ast.Node) error {
 		sort.Slice(keys, func(i, j int) bool {
 			return keys[i].String() < keys[j].String()
 		})
+		operands := []ast.Expression{}
 		for _, k := range keys {
-			err := c.Compile(k)
-			if err != nil {
-				return err
-			}
-			err = c.Compile(node.Pairs[k])
-			if err != nil {
-				return err
-			}
+			operands = append(operands, k, node.Pairs[k])
+		}
+		err := c.compileOperands(operands...)
+		if err != nil {
+			return err
 		}
 		c.emit(code.OpHash, len(node.Pairs)*2)
 	case *ast.ArrayLiteral:
-		for _, el := range node.Elements {
-			err := c.Compile(el)
-			if err != nil {
-				return err
-			}
+		err := c.compileOperands(node.Elements...)
+		if err != nil {
+			return err
 		}
 		c.emit(code.OpArray, len(node.Elements))
 	case *ast.StringLiteral:
@@ -270,6 +445,9 @@ func (c *Compiler) Compile(node ast.Node) error {
 	case *ast.IntegerLiteral:
 		integer := &object.Integer{Value: node.Value}
 		c.emit(code.OpConstant, c.addConstant(integer))
+	case *ast.FloatLiteral:
+		float := &object.Float{Value: node.Value}
+		c.emit(code.OpConstant, c.addConstant(float))
 	case *ast.Boolean:
 		if node.Value {
 			c.emit(code.OpTrue)
@@ -285,14 +463,42 @@ func (c *Compiler) Bytecode() *Bytecode {
 	return &Bytecode{
 		Instructions: c.currentInstructions(),
 		Constants:    c.constants,
+		SourceMap:    c.scopes[c.scopeIndex].sourceMap,
 	}
 }
 
+// errorf returns an error prefixed with the position of the node being compiled
+func (c *Compiler) errorf(format string, a ...interface{}) error {
+	return fmt.Errorf("%s: %s", c.pos, fmt.Sprintf(format, a...))
+}
+
 func (c *Compiler) addConstant(obj object.Object) int {
+	key, ok := constantKey(obj)
+	if ok {
+		if i, ok := c.constantIndex[key]; ok {
+			return i
+		}
+	}
+
 	c.constants = append(c.constants, obj)
+	if ok {
+		c.constantIndex[key] = len(c.constants) - 1
+	}
 	return len(c.constants) - 1
 }
 
+// constantKey returns the key of integer and string constants in
+// constantIndex. They are immutable, so equal ones can be shared.
+func constantKey(obj object.Object) (interface{}, bool) {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return obj.Value, true
+	case *object.String:
+		return obj.Value, true
+	}
+	return nil, false
+}
+
 func (c *Compiler) emit(op code.Opcode, operands ...int) int {
 	ins := code.Make(op, operands...)
 	pos := c.addInstruction(ins)
@@ -311,10 +517,26 @@ func (c *Compiler) addInstruction(ins []byte) int {
 	updatedInstructions := append(c.currentInstructions(), ins...)
 
 	c.scopes[c.scopeIndex].instructions = updatedInstructions
+	c.addSourcePosition(posNewInstruction)
 
 	return posNewInstruction
 }
 
+// addSourcePosition maps the instruction at offset to the position of the
+// node being compiled, unless the previous instruction already maps there
+func (c *Compiler) addSourcePosition(offset int) {
+	if !c.pos.IsValid() {
+		return
+	}
+
+	sm := c.scopes[c.scopeIndex].sourceMap
+	if n := len(sm); n > 0 && sm[n-1].Pos == c.pos {
+		return
+	}
+
+	c.scopes[c.scopeIndex].sourceMap = append(sm, code.SourcePosition{Offset: offset, Pos: c.pos})
+}
+
 func (c *Compiler) setLastInstruction(op code.Opcode, pos int) {
 	previous := c.scopes[c.scopeIndex].lastInstruction
 	last := EmittedInstruction{Opcode: op, Position: pos}
@@ -340,6 +562,22 @@ func (c *Compiler) removeLastPop() {
 
 	c.scopes[c.scopeIndex].instructions = new
 	c.scopes[c.scopeIndex].lastInstruction = previous
+
+	sm := c.scopes[c.scopeIndex].sourceMap
+	for len(sm) > 0 && sm[len(sm)-1].Offset >= last.Position {
+		sm = sm[:len(sm)-1]
+	}
+	c.scopes[c.scopeIndex].sourceMap = sm
+}
+
+// leaveBlockValue leaves the value of the block just compiled on the stack:
+// that of its final expression statement, or null if it has none
+func (c *Compiler) leaveBlockValue() {
+	if c.lastInstructionIs(code.OpPop) {
+		c.removeLastPop()
+	} else {
+		c.emit(code.OpNull)
+	}
 }
 
 func (c *Compiler) replaceLastPopWithReturn() {
@@ -349,6 +587,41 @@ func (c *Compiler) replaceLastPopWithReturn() {
 	c.scopes[c.scopeIndex].lastInstruction.Opcode = code.OpReturnValue
 }
 
+// markTailCalls turns calls whose result is returned straight away, possibly
+// after unconditional jumps, into tail calls
+func markTailCalls(ins code.Instructions) {
+	for ip := 0; ip < len(ins); {
+		def, err := code.Lookup(ins[ip])
+		if err != nil {
+			return
+		}
+		_, read := code.ReadOperands(def, ins[ip+1:])
+		next := ip + 1 + read
+
+		if code.Opcode(ins[ip]) == code.OpCall && returnsAt(ins, next) {
+			ins[ip] = byte(code.OpTailCall)
+		}
+		ip = next
+	}
+}
+
+// returnsAt reports whether execution from ip reaches OpReturnValue without
+// touching the stack
+func returnsAt(ins code.Instructions, ip int) bool {
+	// bound the number of jumps followed, in case they form a cycle
+	for jumps := 0; ip < len(ins) && jumps <= len(ins); jumps++ {
+		switch code.Opcode(ins[ip]) {
+		case code.OpReturnValue:
+			return true
+		case code.OpJump:
+			ip = int(code.ReadUint16(ins[ip+1:]))
+		default:
+			return false
+		}
+	}
+	return false
+}
+
 func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
 	ins := c.currentInstructions()
 
@@ -387,6 +660,59 @@ func (c *Compiler) leaveScope() code.Instructions {
 	return instructions
 }
 
+// enterLoop starts a loop at the current position
+func (c *Compiler) enterLoop(iterator bool) *Loop {
+	loop := &Loop{
+		Start:    len(c.currentInstructions()),
+		Iterator: iterator,
+		Operands: c.scopes[c.scopeIndex].operands,
+	}
+	c.scopes[c.scopeIndex].loops = append(c.scopes[c.scopeIndex].loops, loop)
+	return loop
+}
+
+// leaveLoop patches the breaks out of the innermost loop to jump to the
+// current position
+func (c *Compiler) leaveLoop() {
+	loops := c.scopes[c.scopeIndex].loops
+	loop := loops[len(loops)-1]
+	for _, pos := range loop.Breaks {
+		c.changeOperand(pos, len(c.currentInstructions()))
+	}
+	c.scopes[c.scopeIndex].loops = loops[:len(loops)-1]
+}
+
+// popOperands pops the operands pushed since loop was entered, which a break
+// or continue in the middle of an expression leaves behind
+func (c *Compiler) popOperands(loop *Loop) {
+	for i := loop.Operands; i < c.scopes[c.scopeIndex].operands; i++ {
+		c.emit(code.OpPop)
+	}
+}
+
+// compileOperands compiles the operands of an expression in order. Each is
+// left on the stack while those after it are compiled.
+func (c *Compiler) compileOperands(operands ...ast.Expression) error {
+	for _, operand := range operands {
+		err := c.Compile(operand)
+		if err != nil {
+			return err
+		}
+		c.scopes[c.scopeIndex].operands++
+	}
+	c.scopes[c.scopeIndex].operands -= len(operands)
+	return nil
+}
+
+// currentLoop returns the innermost loop in the current function, or nil
+func (c *Compiler) currentLoop() *Loop {
+	loops := c.scopes[c.scopeIndex].loops
+	if len(loops) == 0 {
+		return nil
+	}
+	return loops[len(loops)-1]
+}
+
 func (c *Compiler) loadSymbol(s Symbol) {
 	switch s.Scope {
 	case GlobalScope:
@@ -397,10 +723,38 @@ func (c *Compiler) loadSymbol(s Symbol) {
 		c.emit(code.OpGetBuiltin, s.Index)
 	case FreeScope:
 		c.emit(code.OpGetFree, s.Index)
+	case FunctionScope:
+		c.emit(code.OpCurrentClosure)
+	}
+}
+
+// captureSymbol pushes the cell of the local or free variable s, to be shared
+// with a closure. The current closure is pushed as is for OpClosure to wrap
+func (c *Compiler) captureSymbol(s Symbol) {
+	switch s.Scope {
+	case LocalScope:
+		c.emit(code.OpCaptureLocal, s.Index)
+	case FreeScope:
+		c.emit(code.OpCaptureFree, s.Index)
+	case FunctionScope:
+		c.emit(code.OpCurrentClosure)
+	}
+}
+
+// storeSymbol pops the top of the stack into the variable s
+func (c *Compiler) storeSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpSetGlobal, s.Index)
+	case LocalScope:
+		c.emit(code.OpSetLocal, s.Index)
+	case FreeScope:
+		c.emit(code.OpSetFree, s.Index)
 	}
 }
 
 type Bytecode struct {
 	Instructions code.Instructions
 	Constants    []object.Object
+	SourceMap    code.SourceMap
 }
@@ -2,6 +2,7 @@ package compiler
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/mikeraimondi/monkey/ast"
@@ -17,13 +18,13 @@ type compilerTestCase struct {
 	expectedInstructions []code.Instructions
 }
 
-func runCompilerTests(t *testing.T, tests []compilerTestCase) {
+func runCompilerTests(t *testing.T, tests []compilerTestCase, options ...Option) {
 	t.Helper()
 	for _, tt := range tests {
 		t.Run(tt.input, func(t *testing.T) {
-			program := parse(tt.input)
+			program := parse(t, tt.input)
 
-			compiler := New()
+			compiler := New(options...)
 			err := compiler.Compile(program)
 			if err != nil {
 				t.Fatalf("compiler error: %s", err)
@@ -44,10 +45,16 @@ func runCompilerTests(t *testing.T, tests []compilerTestCase) {
 	}
 }
 
-func parse(input string) *ast.Program {
+func parse(t testing.TB, input string) *ast.Program {
+	t.Helper()
+
 	l := lexer.New(input)
 	p := parser.New(l)
-	return p.ParseProgram()
+	program := p.ParseProgram()
+	if errors := p.Errors(); len(errors) != 0 {
+		t.Fatalf("parser errors for %q: %s", input, strings.Join(errors, "; "))
+	}
+	return program
 }
 
 func testInstructions(
@@ -98,6 +105,11 @@ func testConstants(t *testing.T,
 				return fmt.Errorf("constant %d - testIntegerObject failed: %s",
 					i, err)
 			}
+		case float64:
+			err := testFloatObject(constant, actual[i])
+			if err != nil {
+				return fmt.Errorf("constant %d - testFloatObject failed: %s", i, err)
+			}
 		case string:
 			err := testStringObject(constant, actual[i])
 			if err != nil {
@@ -134,6 +146,19 @@ func testIntegerObject(expected int64, actual object.Object) error {
 	return nil
 }
 
+func testFloatObject(expected float64, actual object.Object) error {
+	result, ok := actual.(*object.Float)
+	if !ok {
+		return fmt.Errorf("object is not Float. got %T (%+v)", actual, actual)
+	}
+
+	if result.Value != expected {
+		return fmt.Errorf("object has wrong value. got %g. expected %g", result.Value, expected)
+	}
+
+	return nil
+}
+
 func testStringObject(expected string, actual object.Object) error {
 	result, ok := actual.(*object.String)
 	if !ok {
@@ -155,8 +180,7 @@ func TestIntegerArithmetic(t *testing.T) {
 			expectedConstants: []interface{}{1, 2},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
-				code.Make(code.OpConstant, 1),
-				code.Make(code.OpAdd),
+				code.Make(code.OpAddConst, 1),
 				code.Make(code.OpPop),
 			},
 		},
@@ -175,8 +199,7 @@ func TestIntegerArithmetic(t *testing.T) {
 			expectedConstants: []interface{}{1, 2},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
-				code.Make(code.OpConstant, 1),
-				code.Make(code.OpSub),
+				code.Make(code.OpSubConst, 1),
 				code.Make(code.OpPop),
 			},
 		},
@@ -190,6 +213,16 @@ func TestIntegerArithmetic(t *testing.T) {
 				code.Make(code.OpPop),
 			},
 		},
+		{
+			input:             "5 % 2",
+			expectedConstants: []interface{}{5, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpMod),
+				code.Make(code.OpPop),
+			},
+		},
 		{
 			input:             "2 / 1",
 			expectedConstants: []interface{}{2, 1},
@@ -214,6 +247,23 @@ func TestIntegerArithmetic(t *testing.T) {
 	runCompilerTests(t, tests)
 }
 
+func TestFloatArithmetic(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "1.5 * 2",
+			expectedConstants: []interface{}{1.5, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpMul),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
 func TestBooleanExpressions(t *testing.T) {
 	tests := []compilerTestCase{
 		{
@@ -306,6 +356,61 @@ func TestBooleanExpressions(t *testing.T) {
 	runCompilerTests(t, tests)
 }
 
+func TestLogicalOperators(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "1 <= 2",
+			expectedConstants: []interface{}{2, 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpGreaterThanOrEqual),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1 >= 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpGreaterThanOrEqual),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "true && false",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthyOrPop, 5),
+				// 0004
+				code.Make(code.OpFalse),
+				// 0005
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "true || false",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpTruthyOrPop, 5),
+				// 0004
+				code.Make(code.OpFalse),
+				// 0005
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
 func TestConditionals(t *testing.T) {
 	tests := []compilerTestCase{
 		{
@@ -423,10 +528,39 @@ func TestStringExpressions(t *testing.T) {
 		{
 			input:             `"mon" + "key"`,
 			expectedConstants: []interface{}{"mon", "key"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpAddConst, 1),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestConstantInterning(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `1 + 1.5 + 1 + 1.5`,
+			expectedConstants: []interface{}{1, 1.5, 1.5},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpAddConst, 1),
+				code.Make(code.OpAddConst, 0),
+				code.Make(code.OpAddConst, 2),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `["a", "b", "a", 1]`,
+			expectedConstants: []interface{}{"a", "b", 1},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpConstant, 1),
-				code.Make(code.OpAdd),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpArray, 4),
 				code.Make(code.OpPop),
 			},
 		},
@@ -435,6 +569,26 @@ func TestStringExpressions(t *testing.T) {
 	runCompilerTests(t, tests)
 }
 
+func TestConstantInterningWithState(t *testing.T) {
+	symbolTable := NewSymbolTable()
+	var constants []object.Object
+
+	// as in the REPL, each line is compiled with the constants of the last
+	for i := 0; i < 3; i++ {
+		comp := NewWithState(symbolTable, constants)
+		err := comp.Compile(parse(t, `"monkey"; 1 + 2`))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		constants = comp.Bytecode().Constants
+	}
+
+	err := testConstants(t, []interface{}{"monkey", 1, 2}, constants)
+	if err != nil {
+		t.Fatalf("testConstants failed: %s", err)
+	}
+}
+
 func TestArrayLiterals(t *testing.T) {
 	tests := []compilerTestCase{
 		{
@@ -461,11 +615,9 @@ func TestArrayLiterals(t *testing.T) {
 			expectedConstants: []interface{}{1, 2, 3, 4, 5, 6},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
-				code.Make(code.OpConstant, 1),
-				code.Make(code.OpAdd),
+				code.Make(code.OpAddConst, 1),
 				code.Make(code.OpConstant, 2),
-				code.Make(code.OpConstant, 3),
-				code.Make(code.OpSub),
+				code.Make(code.OpSubConst, 3),
 				code.Make(code.OpConstant, 4),
 				code.Make(code.OpConstant, 5),
 				code.Make(code.OpMul),
@@ -507,8 +659,7 @@ func TestHashLiterals(t *testing.T) {
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpConstant, 1),
-				code.Make(code.OpConstant, 2),
-				code.Make(code.OpAdd),
+				code.Make(code.OpAddConst, 2),
 				code.Make(code.OpConstant, 3),
 				code.Make(code.OpConstant, 4),
 				code.Make(code.OpConstant, 5),
@@ -526,29 +677,27 @@ func TestIndexExpressions(t *testing.T) {
 	tests := []compilerTestCase{
 		{
 			input:             "[1, 2, 3][1 + 1]",
-			expectedConstants: []interface{}{1, 2, 3, 1, 1},
+			expectedConstants: []interface{}{1, 2, 3},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpConstant, 1),
 				code.Make(code.OpConstant, 2),
 				code.Make(code.OpArray, 3),
-				code.Make(code.OpConstant, 3),
-				code.Make(code.OpConstant, 4),
-				code.Make(code.OpAdd),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpAddConst, 0),
 				code.Make(code.OpIndex),
 				code.Make(code.OpPop),
 			},
 		},
 		{
 			input:             "{1: 2}[2 - 1]",
-			expectedConstants: []interface{}{1, 2, 2, 1},
+			expectedConstants: []interface{}{1, 2},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpConstant, 1),
 				code.Make(code.OpHash, 2),
-				code.Make(code.OpConstant, 2),
-				code.Make(code.OpConstant, 3),
-				code.Make(code.OpSub),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSubConst, 0),
 				code.Make(code.OpIndex),
 				code.Make(code.OpPop),
 			},
@@ -629,8 +778,7 @@ func TestFunctions(t *testing.T) {
 				10,
 				[]code.Instructions{
 					code.Make(code.OpConstant, 0),
-					code.Make(code.OpConstant, 1),
-					code.Make(code.OpAdd),
+					code.Make(code.OpAddConst, 1),
 					code.Make(code.OpReturnValue),
 				},
 			},
@@ -646,8 +794,7 @@ func TestFunctions(t *testing.T) {
 				10,
 				[]code.Instructions{
 					code.Make(code.OpConstant, 0),
-					code.Make(code.OpConstant, 1),
-					code.Make(code.OpAdd),
+					code.Make(code.OpAddConst, 1),
 					code.Make(code.OpReturnValue),
 				},
 			},
@@ -739,7 +886,7 @@ func TestFunctionCalls(t *testing.T) {
 			`,
 			expectedConstants: []interface{}{
 				[]code.Instructions{
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpGetLocal0),
 					code.Make(code.OpReturnValue),
 				},
 				24,
@@ -759,11 +906,11 @@ func TestFunctionCalls(t *testing.T) {
 			`,
 			expectedConstants: []interface{}{
 				[]code.Instructions{
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpGetLocal0),
 					code.Make(code.OpPop),
-					code.Make(code.OpGetLocal, 1),
+					code.Make(code.OpGetLocal1),
 					code.Make(code.OpPop),
-					code.Make(code.OpGetLocal, 2),
+					code.Make(code.OpGetLocal2),
 					code.Make(code.OpReturnValue),
 				},
 				24,
@@ -819,7 +966,7 @@ func TestLetStatementScopes(t *testing.T) {
 				[]code.Instructions{
 					code.Make(code.OpConstant, 0),
 					code.Make(code.OpSetLocal, 0),
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpGetLocal0),
 					code.Make(code.OpReturnValue),
 				},
 			},
@@ -844,8 +991,8 @@ func TestLetStatementScopes(t *testing.T) {
 					code.Make(code.OpSetLocal, 0),
 					code.Make(code.OpConstant, 1),
 					code.Make(code.OpSetLocal, 1),
-					code.Make(code.OpGetLocal, 0),
-					code.Make(code.OpGetLocal, 1),
+					code.Make(code.OpGetLocal0),
+					code.Make(code.OpGetLocal1),
 					code.Make(code.OpAdd),
 					code.Make(code.OpReturnValue),
 				},
@@ -886,7 +1033,7 @@ func TestBuiltins(t *testing.T) {
 				[]code.Instructions{
 					code.Make(code.OpGetBuiltin, 0),
 					code.Make(code.OpArray, 0),
-					code.Make(code.OpCall, 1),
+					code.Make(code.OpTailCall, 1),
 					code.Make(code.OpReturnValue),
 				},
 			},
@@ -913,12 +1060,12 @@ func TestClosures(t *testing.T) {
 			expectedConstants: []interface{}{
 				[]code.Instructions{
 					code.Make(code.OpGetFree, 0),
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpGetLocal0),
 					code.Make(code.OpAdd),
 					code.Make(code.OpReturnValue),
 				},
 				[]code.Instructions{
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpCaptureLocal, 0),
 					code.Make(code.OpClosure, 0, 1),
 					code.Make(code.OpReturnValue),
 				},
@@ -943,18 +1090,18 @@ func TestClosures(t *testing.T) {
 					code.Make(code.OpGetFree, 0),
 					code.Make(code.OpGetFree, 1),
 					code.Make(code.OpAdd),
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpGetLocal0),
 					code.Make(code.OpAdd),
 					code.Make(code.OpReturnValue),
 				},
 				[]code.Instructions{
-					code.Make(code.OpGetFree, 0),
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpCaptureFree, 0),
+					code.Make(code.OpCaptureLocal, 0),
 					code.Make(code.OpClosure, 0, 2),
 					code.Make(code.OpReturnValue),
 				},
 				[]code.Instructions{
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpCaptureLocal, 0),
 					code.Make(code.OpClosure, 1, 1),
 					code.Make(code.OpReturnValue),
 				},
@@ -995,22 +1142,22 @@ func TestClosures(t *testing.T) {
 					code.Make(code.OpAdd),
 					code.Make(code.OpGetFree, 1),
 					code.Make(code.OpAdd),
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpGetLocal0),
 					code.Make(code.OpAdd),
 					code.Make(code.OpReturnValue),
 				},
 				[]code.Instructions{
 					code.Make(code.OpConstant, 2),
 					code.Make(code.OpSetLocal, 0),
-					code.Make(code.OpGetFree, 0),
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpCaptureFree, 0),
+					code.Make(code.OpCaptureLocal, 0),
 					code.Make(code.OpClosure, 4, 2),
 					code.Make(code.OpReturnValue),
 				},
 				[]code.Instructions{
 					code.Make(code.OpConstant, 1),
 					code.Make(code.OpSetLocal, 0),
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpCaptureLocal, 0),
 					code.Make(code.OpClosure, 5, 1),
 					code.Make(code.OpReturnValue),
 				},
@@ -1026,3 +1173,416 @@ func TestClosures(t *testing.T) {
 
 	runCompilerTests(t, tests)
 }
+
+func TestRecursiveFunctions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `
+			let countDown = fn(x) { countDown(x - 1); };
+			countDown(1);
+			`,
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpGetGlobal, 0),
+					code.Make(code.OpGetLocal0),
+					code.Make(code.OpSubConst, 0),
+					code.Make(code.OpTailCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpCall, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `
+			let wrapper = fn() {
+				let countDown = fn(x) { countDown(x - 1); };
+				countDown(1);
+			};
+			wrapper();
+			`,
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpCurrentClosure),
+					code.Make(code.OpGetLocal0),
+					code.Make(code.OpSubConst, 0),
+					code.Make(code.OpTailCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpClosure, 1, 0),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpGetLocal0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpTailCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpCall, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `
+			let wrapper = fn() {
+				let f = fn() {
+					fn() { f }
+				};
+			};
+			`,
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpCurrentClosure),
+					code.Make(code.OpClosure, 0, 1),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpClosure, 1, 0),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpReturn),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpSetGlobal, 0),
+			},
+		},
+		{
+			// global functions look themselves up when called, as they may
+			// be reassigned
+			input: `
+			let f = fn() { f = 1; };
+			`,
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSetGlobal, 0),
+					code.Make(code.OpGetGlobal, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpSetGlobal, 0),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestTailCalls(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `fn(f) { if (f) { f() } else { 1 + f() } }`,
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpGetLocal0),
+					code.Make(code.OpJumpNotTruthy, 10),
+					code.Make(code.OpGetLocal0),
+					code.Make(code.OpTailCall, 0),
+					code.Make(code.OpJump, 17),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpGetLocal0),
+					code.Make(code.OpCall, 0),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `fn(f) { f(); return f(); }`,
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetLocal0),
+					code.Make(code.OpCall, 0),
+					code.Make(code.OpPop),
+					code.Make(code.OpGetLocal0),
+					code.Make(code.OpTailCall, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestLoops(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `while (true) { 10; break; continue; }`,
+			expectedConstants: []interface{}{10},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 17),
+				// 0004
+				code.Make(code.OpConstant, 0),
+				// 0007
+				code.Make(code.OpPop),
+				// 0008
+				code.Make(code.OpJump, 17),
+				// 0011
+				code.Make(code.OpJump, 0),
+				// 0014
+				code.Make(code.OpJump, 0),
+			},
+		},
+		{
+			input:             `for (x in []) { break; }`,
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpArray, 0),
+				// 0003
+				code.Make(code.OpIterInit),
+				// 0004
+				code.Make(code.OpIterNext, 18),
+				// 0007
+				code.Make(code.OpSetGlobal, 0),
+				// 0010
+				code.Make(code.OpPop),
+				// 0011
+				code.Make(code.OpPop),
+				// 0012
+				code.Make(code.OpJump, 18),
+				// 0015
+				code.Make(code.OpJump, 4),
+			},
+		},
+		{
+			// the operands pushed before a continue are popped
+			input:             `while (true) { len(if (true) { continue; }) }`,
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 25),
+				// 0004
+				code.Make(code.OpGetBuiltin, 0),
+				// 0006
+				code.Make(code.OpTrue),
+				// 0007
+				code.Make(code.OpJumpNotTruthy, 18),
+				// 0010
+				code.Make(code.OpPop),
+				// 0011
+				code.Make(code.OpJump, 0),
+				// 0014
+				code.Make(code.OpNull),
+				// 0015
+				code.Make(code.OpJump, 19),
+				// 0018
+				code.Make(code.OpNull),
+				// 0019
+				code.Make(code.OpCall, 1),
+				// 0021
+				code.Make(code.OpPop),
+				// 0022
+				code.Make(code.OpJump, 0),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestAssignment(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "let x = 1; x = 2;",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "let a = []; a[0] = 1;",
+			expectedConstants: []interface{}{0, 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpArray, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSetIndex),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "fn(a) { fn() { a = 1 } }",
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSetFree, 0),
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpCaptureLocal, 0),
+					code.Make(code.OpClosure, 1, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestAssignmentErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"y = 1", "1:3: undefined variable y"},
+		{"len = 1", "1:5: cannot assign to builtin len"},
+		{"fn() { let f = fn() { f = 1 } }", "1:25: cannot assign to f inside its own definition"},
+	}
+
+	for _, tt := range tests {
+		err := New().Compile(parse(t, tt.input))
+		if err == nil {
+			t.Fatalf("expected compiler error, got none")
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("wrong compiler error. expected %q, got %q", tt.expected, err)
+		}
+	}
+}
+
+func TestLoopControlOutsideLoop(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"break;", "1:1: break outside loop"},
+		{"while (true) { fn() { continue } }", "1:23: continue outside loop"},
+	}
+
+	for _, tt := range tests {
+		err := New().Compile(parse(t, tt.input))
+		if err == nil {
+			t.Fatalf("expected compiler error, got none")
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("wrong compiler error. expected %q, got %q", tt.expected, err)
+		}
+	}
+}
+
+func TestReturnOutsideFunction(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"return 5;", "1:1: return outside function"},
+		{"let f = fn() { 1 };\nif (true) { return f(); }", "2:13: return outside function"},
+	}
+
+	for _, tt := range tests {
+		err := New().Compile(parse(t, tt.input))
+		if err == nil {
+			t.Fatalf("expected compiler error, got none")
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("wrong compiler error. expected %q, got %q", tt.expected, err)
+		}
+	}
+}
+
+func TestCompilerErrorPositions(t *testing.T) {
+	l := lexer.NewWithFilename("test.mk", "let a = 1;\nlet b = fn() {\n  a + c\n};")
+	program := parser.New(l).ParseProgram()
+
+	err := New().Compile(program)
+	if err == nil {
+		t.Fatalf("expected compiler error, got none")
+	}
+
+	expected := "test.mk:3:7: undefined variable c"
+	if err.Error() != expected {
+		t.Errorf("wrong compiler error. expected %q, got %q", expected, err)
+	}
+}
+
+func TestSourceMap(t *testing.T) {
+	program := parse(t, "1;\n\"two\";\nfn() { 3 }")
+
+	compiler := New()
+	err := compiler.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	bytecode := compiler.Bytecode()
+
+	tests := []struct {
+		offset   int
+		expected string
+	}{
+		{0, "1:1"}, // OpConstant 0
+		{3, "1:1"}, // OpPop
+		{4, "2:1"}, // OpConstant 1
+		{8, "3:1"}, // OpClosure 3 0
+	}
+
+	for _, tt := range tests {
+		pos, ok := bytecode.SourceMap.Lookup(tt.offset)
+		if !ok {
+			t.Errorf("no position for offset %d", tt.offset)
+			continue
+		}
+		if pos.String() != tt.expected {
+			t.Errorf("wrong position for offset %d. expected %s, got %s",
+				tt.offset, tt.expected, pos)
+		}
+	}
+
+	fn, ok := bytecode.Constants[3].(*object.CompiledFunction)
+	if !ok {
+		t.Fatalf("constant 3 not a function: %T", bytecode.Constants[3])
+	}
+	if pos, _ := fn.SourceMap.Lookup(0); pos.String() != "3:8" {
+		t.Errorf("wrong position for function body. expected 3:8, got %s", pos)
+	}
+}
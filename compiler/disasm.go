@@ -0,0 +1,208 @@
+package compiler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mikeraimondi/monkey/code"
+	"github.com/mikeraimondi/monkey/object"
+)
+
+// Disassemble returns a listing of the program's instructions, followed by
+// those of the functions they create, and the functions those create in
+// turn. Jump targets are labelled, and operands are annotated with the
+// constants, builtins, locals and free variables they refer to, and with the
+// names of globals if globals is not nil.
+func Disassemble(b *Bytecode, globals *SymbolTable) string {
+	d := &disassembler{
+		bytecode:    b,
+		globalNames: map[int]string{},
+		listed:      map[int]bool{},
+	}
+	if globals != nil {
+		for _, s := range globals.Symbols() {
+			if s.Scope == GlobalScope {
+				d.globalNames[s.Index] = s.Name
+			}
+		}
+	}
+
+	d.out.WriteString("main:\n")
+	d.function(b.Instructions, nil)
+
+	// functions are listed as they are found, so that nested ones follow
+	// the ones creating them
+	for i := 0; i < len(d.queue); i++ {
+		index := d.queue[i]
+		fn := b.Constants[index].(*object.CompiledFunction)
+		fmt.Fprintf(&d.out, "\nconstant %d: %s\n", index, fn.Inspect())
+		d.function(fn.Instructions, fn)
+	}
+
+	return d.out.String()
+}
+
+type disassembler struct {
+	bytecode    *Bytecode
+	globalNames map[int]string
+	out         strings.Builder
+
+	// queue holds the indexes of the function constants to list, and
+	// listed those already queued
+	queue  []int
+	listed map[int]bool
+}
+
+// function lists the instructions of fn, or of the main program if fn is nil
+func (d *disassembler) function(ins code.Instructions, fn *object.CompiledFunction) {
+	labels := jumpLabels(ins)
+
+	for ip := 0; ip < len(ins); {
+		if label, ok := labels[ip]; ok {
+			fmt.Fprintf(&d.out, "%s:\n", label)
+		}
+
+		def, err := code.Lookup(ins[ip])
+		if err != nil {
+			fmt.Fprintf(&d.out, "  %04d ERROR: %s\n", ip, err)
+			ip++
+			continue
+		}
+		if ip+1+def.Width() > len(ins) {
+			fmt.Fprintf(&d.out, "  %04d ERROR: truncated %s\n", ip, def.Name)
+			return
+		}
+		operands, read := code.ReadOperands(def, ins[ip+1:])
+
+		text := def.Name
+		for _, o := range operands {
+			text += " " + strconv.Itoa(o)
+		}
+		if note := d.annotate(code.Opcode(ins[ip]), operands, labels, fn); note != "" {
+			text = fmt.Sprintf("%-24s ; %s", text, note)
+		}
+		fmt.Fprintf(&d.out, "  %04d %s\n", ip, text)
+
+		ip += 1 + read
+	}
+
+	// jumps past the last instruction end the function
+	if label, ok := labels[len(ins)]; ok {
+		fmt.Fprintf(&d.out, "%s:\n", label)
+	}
+}
+
+// annotate describes what the operands of an instruction refer to
+func (d *disassembler) annotate(
+	op code.Opcode,
+	operands []int,
+	labels map[int]string,
+	fn *object.CompiledFunction,
+) string {
+	switch op {
+	case code.OpCompareJump:
+		def, err := code.Lookup(byte(operands[1]))
+		if err != nil {
+			return labels[operands[0]]
+		}
+		return fmt.Sprintf("%s unless %s", labels[operands[0]], def.Name)
+	case code.OpCompareConstJump:
+		def, err := code.Lookup(byte(operands[2]))
+		if err != nil {
+			return labels[operands[0]]
+		}
+		return fmt.Sprintf("%s unless %s %s", labels[operands[0]], def.Name, d.constant(operands[1]))
+	}
+	if code.IsJump(op) {
+		return labels[operands[0]]
+	}
+
+	switch op {
+	case code.OpConstant, code.OpClosure, code.OpAddConst, code.OpSubConst:
+		return d.constant(operands[0])
+	case code.OpIncrLocal:
+		local := d.local(operands[0], fn)
+		if local == "" {
+			local = "local " + strconv.Itoa(operands[0])
+		}
+		return local + " += " + d.constant(operands[1])
+	case code.OpGetGlobal, code.OpSetGlobal:
+		return d.globalNames[operands[0]]
+	case code.OpGetBuiltin:
+		if operands[0] < len(object.Builtins) {
+			return object.Builtins[operands[0]].Name
+		}
+	case code.OpGetLocal, code.OpSetLocal, code.OpCaptureLocal:
+		return d.local(operands[0], fn)
+	case code.OpGetLocal0, code.OpGetLocal1, code.OpGetLocal2, code.OpGetLocal3:
+		return d.local(int(op-code.OpGetLocal0), fn)
+	case code.OpGetFree, code.OpSetFree, code.OpCaptureFree:
+		if fn != nil && operands[0] < len(fn.Free) {
+			return fn.Free[operands[0]]
+		}
+	case code.OpCurrentClosure:
+		if fn != nil {
+			return fn.Name
+		}
+	}
+	return ""
+}
+
+// constant describes the constant at index, queueing functions to be listed
+func (d *disassembler) constant(index int) string {
+	if index >= len(d.bytecode.Constants) {
+		return "out of range"
+	}
+	c := d.bytecode.Constants[index]
+	if _, ok := c.(*object.CompiledFunction); ok && !d.listed[index] {
+		d.listed[index] = true
+		d.queue = append(d.queue, index)
+	}
+	if s, ok := c.(*object.String); ok {
+		return strconv.Quote(s.Value)
+	}
+	return c.Inspect()
+}
+
+// local returns the name of a local of fn, if it is known
+func (d *disassembler) local(index int, fn *object.CompiledFunction) string {
+	switch {
+	case fn == nil:
+		return ""
+	case index < len(fn.Locals):
+		return fn.Locals[index]
+	case index < len(fn.Parameters):
+		return fn.Parameters[index]
+	}
+	return ""
+}
+
+// jumpLabels names the targets of the jumps in ins L1, L2, ... in the order
+// they appear
+func jumpLabels(ins code.Instructions) map[int]string {
+	targets := map[int]bool{}
+	for ip := 0; ip < len(ins); {
+		def, err := code.Lookup(ins[ip])
+		if err != nil {
+			ip++
+			continue
+		}
+		if ip+1+def.Width() > len(ins) {
+			break
+		}
+		operands, read := code.ReadOperands(def, ins[ip+1:])
+		if code.IsJump(code.Opcode(ins[ip])) {
+			targets[operands[0]] = true
+		}
+		ip += 1 + read
+	}
+
+	labels := map[int]string{}
+	for ip := 0; ip <= len(ins); ip++ {
+		if targets[ip] {
+			labels[ip] = "L" + strconv.Itoa(len(labels)+1)
+		}
+	}
+	return labels
+}
@@ -0,0 +1,102 @@
+package compiler
+
+import (
+	"testing"
+
+	"github.com/mikeraimondi/monkey/code"
+	"github.com/mikeraimondi/monkey/object"
+)
+
+func TestDisassemble(t *testing.T) {
+	input := `
+	let mk = fn(x) { fn(y) { x + len(y) } };
+	while (true) { mk("s") }
+	`
+
+	comp := New()
+	err := comp.Compile(parse(t, input))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	expected := `main:
+  0000 OpClosure 1 0            ; fn mk(x)
+  0004 OpSetGlobal 0            ; mk
+L1:
+  0007 OpTrue
+  0008 OpJumpNotTruthy 23       ; L2
+  0011 OpGetGlobal 0            ; mk
+  0014 OpConstant 2             ; "s"
+  0017 OpCall 1
+  0019 OpPop
+  0020 OpJump 7                 ; L1
+L2:
+
+constant 1: fn mk(x)
+  0000 OpCaptureLocal 0         ; x
+  0002 OpClosure 0 1            ; fn(y)
+  0006 OpReturnValue
+
+constant 0: fn(y)
+  0000 OpGetFree 0              ; x
+  0002 OpGetBuiltin 0           ; len
+  0004 OpGetLocal0              ; y
+  0005 OpCall 1
+  0007 OpAdd
+  0008 OpReturnValue
+`
+	if actual := Disassemble(comp.Bytecode(), comp.symbolTable); actual != expected {
+		t.Errorf("wrong listing.\nexpected %s\ngot %s", expected, actual)
+	}
+}
+
+func TestDisassembleLocals(t *testing.T) {
+	input := `fn(a) { let b = a; let b = b + 1; b }`
+
+	comp := New()
+	err := comp.Compile(parse(t, input))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	// shadowed locals keep their name
+	expected := `main:
+  0000 OpClosure 1 0            ; fn(a)
+  0004 OpPop
+
+constant 1: fn(a)
+  0000 OpGetLocal0              ; a
+  0001 OpSetLocal 1             ; b
+  0003 OpGetLocal1              ; b
+  0004 OpAddConst 0             ; 1
+  0007 OpSetLocal 2             ; b
+  0009 OpGetLocal2              ; b
+  0010 OpReturnValue
+`
+	if actual := Disassemble(comp.Bytecode(), nil); actual != expected {
+		t.Errorf("wrong listing.\nexpected %s\ngot %s", expected, actual)
+	}
+}
+
+func TestDisassembleInvalid(t *testing.T) {
+	b := &Bytecode{
+		Instructions: concatInstructions([]code.Instructions{
+			{255},
+			code.Make(code.OpConstant, 1),
+			code.Make(code.OpJump, 0),
+			code.Make(code.OpGetLocal, 0)[:1],
+		}),
+		Constants: []object.Object{&object.Integer{Value: 1}},
+	}
+
+	expected := `main:
+L1:
+  0000 ERROR: opcode 255 undefinied
+  0001 OpConstant 1             ; out of range
+  0004 OpJump 0                 ; L1
+  0007 ERROR: truncated OpGetLocal
+`
+	if actual := Disassemble(b, nil); actual != expected {
+		t.Errorf("wrong listing.\nexpected %s\ngot %s", expected, actual)
+	}
+}
@@ -0,0 +1,348 @@
+package compiler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/mikeraimondi/monkey/code"
+	"github.com/mikeraimondi/monkey/object"
+	"github.com/mikeraimondi/monkey/token"
+)
+
+// BytecodeMagic starts every file of serialized bytecode. Its NUL byte can
+// not appear in Monkey source.
+const BytecodeMagic = "\x00mkc"
+
+// BytecodeVersion is the version of the serialization format. It must be
+// incremented whenever the format, the opcodes or the builtins change, as
+// builtins are referred to by index.
+const BytecodeVersion = 5
+
+// tags of the serialized constants
+const (
+	tagInteger byte = iota + 1
+	tagFloat
+	tagString
+	tagFunction
+)
+
+// IsBytecode reports whether data starts like serialized bytecode
+func IsBytecode(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(BytecodeMagic))
+}
+
+// MarshalBinary serializes the bytecode. Its constants must be integers,
+// floats, strings or compiled functions, as the compiler produces.
+func (b *Bytecode) MarshalBinary() ([]byte, error) {
+	e := &encoder{}
+	e.buf = append(e.buf, BytecodeMagic...)
+	e.uint(BytecodeVersion)
+
+	e.uint(len(b.Constants))
+	for i, c := range b.Constants {
+		err := e.constant(c)
+		if err != nil {
+			return nil, fmt.Errorf("constant %d: %s", i, err)
+		}
+	}
+
+	e.bytes(b.Instructions)
+	e.sourceMap(b.SourceMap)
+
+	return e.buf, nil
+}
+
+// UnmarshalBinary reads bytecode serialized by MarshalBinary, checking that
+// it is well formed
+func (b *Bytecode) UnmarshalBinary(data []byte) error {
+	if !IsBytecode(data) {
+		return fmt.Errorf("invalid bytecode: missing header")
+	}
+	d := &decoder{data: data[len(BytecodeMagic):]}
+
+	if version := d.uint(); d.err == nil && version != BytecodeVersion {
+		return fmt.Errorf("unsupported bytecode version %d, want %d",
+			version, BytecodeVersion)
+	}
+
+	constants := make([]object.Object, d.length())
+	for i := range constants {
+		constants[i] = d.constant()
+	}
+
+	instructions := code.Instructions(d.bytes())
+	sourceMap := d.sourceMap()
+
+	if d.err == nil && len(d.data) != 0 {
+		d.fail("%d bytes of trailing data", len(d.data))
+	}
+	if d.err != nil {
+		return fmt.Errorf("invalid bytecode: %s", d.err)
+	}
+
+	decoded := Bytecode{
+		Instructions: instructions,
+		Constants:    constants,
+		SourceMap:    sourceMap,
+	}
+	err := decoded.validate()
+	if err != nil {
+		return fmt.Errorf("invalid bytecode: %s", err)
+	}
+
+	*b = decoded
+	return nil
+}
+
+// validate checks that the instructions of the program and its functions
+// are well formed and refer to existing constants. vm.Verify checks further
+// that they are safe to run.
+func (b *Bytecode) validate() error {
+	err := b.validateInstructions(b.Instructions)
+	if err != nil {
+		return err
+	}
+
+	for i, c := range b.Constants {
+		fn, ok := c.(*object.CompiledFunction)
+		if !ok {
+			continue
+		}
+		err := b.validateInstructions(fn.Instructions)
+		if err != nil {
+			return fmt.Errorf("constant %d: %s", i, err)
+		}
+	}
+
+	return nil
+}
+
+func (b *Bytecode) validateInstructions(ins code.Instructions) error {
+	err := code.Verify(ins)
+	if err != nil {
+		return err
+	}
+
+	for ip := 0; ip < len(ins); {
+		def, _ := code.Lookup(ins[ip])
+		operands, read := code.ReadOperands(def, ins[ip+1:])
+
+		switch code.Opcode(ins[ip]) {
+		case code.OpConstant, code.OpAddConst, code.OpSubConst:
+			if operands[0] >= len(b.Constants) {
+				return fmt.Errorf("constant %d out of range at %d", operands[0], ip)
+			}
+		case code.OpIncrLocal, code.OpCompareConstJump:
+			if operands[1] >= len(b.Constants) {
+				return fmt.Errorf("constant %d out of range at %d", operands[1], ip)
+			}
+		case code.OpClosure:
+			if operands[0] >= len(b.Constants) {
+				return fmt.Errorf("constant %d out of range at %d", operands[0], ip)
+			}
+			if _, ok := b.Constants[operands[0]].(*object.CompiledFunction); !ok {
+				return fmt.Errorf("constant %d is not a function at %d", operands[0], ip)
+			}
+		}
+
+		ip += 1 + read
+	}
+
+	return nil
+}
+
+type encoder struct {
+	buf     []byte
+	scratch [binary.MaxVarintLen64]byte
+}
+
+func (e *encoder) uint(n int) {
+	size := binary.PutUvarint(e.scratch[:], uint64(n))
+	e.buf = append(e.buf, e.scratch[:size]...)
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.uint(len(b))
+	e.buf = append(e.buf, b...)
+}
+
+func (e *encoder) string(s string) {
+	e.uint(len(s))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *encoder) strings(s []string) {
+	e.uint(len(s))
+	for _, str := range s {
+		e.string(str)
+	}
+}
+
+func (e *encoder) constant(c object.Object) error {
+	switch c := c.(type) {
+	case *object.Integer:
+		e.buf = append(e.buf, tagInteger)
+		size := binary.PutVarint(e.scratch[:], c.Value)
+		e.buf = append(e.buf, e.scratch[:size]...)
+	case *object.Float:
+		e.buf = append(e.buf, tagFloat)
+		binary.BigEndian.PutUint64(e.scratch[:8], math.Float64bits(c.Value))
+		e.buf = append(e.buf, e.scratch[:8]...)
+	case *object.String:
+		e.buf = append(e.buf, tagString)
+		e.string(c.Value)
+	case *object.CompiledFunction:
+		e.buf = append(e.buf, tagFunction)
+		e.string(c.Name)
+		e.strings(c.Parameters)
+		e.strings(c.Locals)
+		e.strings(c.Free)
+		e.uint(c.NumLocals)
+		e.uint(c.NumParameters)
+		e.bytes(c.Instructions)
+		e.sourceMap(c.SourceMap)
+	default:
+		return fmt.Errorf("cannot serialize %s", c.Type())
+	}
+	return nil
+}
+
+func (e *encoder) sourceMap(sm code.SourceMap) {
+	e.uint(len(sm))
+	for _, sp := range sm {
+		e.uint(sp.Offset)
+		e.string(sp.Pos.Filename)
+		e.uint(sp.Pos.Offset)
+		e.uint(sp.Pos.Line)
+		e.uint(sp.Pos.Column)
+	}
+}
+
+// decoder reads serialized bytecode. After the first error, reads return
+// zero values and err is kept.
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (d *decoder) fail(format string, a ...interface{}) {
+	if d.err == nil {
+		d.err = fmt.Errorf(format, a...)
+	}
+	d.data = nil
+}
+
+func (d *decoder) uint() int {
+	if d.err != nil {
+		return 0
+	}
+	n, size := binary.Uvarint(d.data)
+	if size <= 0 || n > math.MaxInt32 {
+		d.fail("malformed integer")
+		return 0
+	}
+	d.data = d.data[size:]
+	return int(n)
+}
+
+// length reads the number of items or bytes that follow, which can not
+// exceed the bytes left
+func (d *decoder) length() int {
+	n := d.uint()
+	if n > len(d.data) {
+		d.fail("unexpected end of data")
+		return 0
+	}
+	return n
+}
+
+func (d *decoder) byte() byte {
+	if d.err != nil {
+		return 0
+	}
+	if len(d.data) == 0 {
+		d.fail("unexpected end of data")
+		return 0
+	}
+	b := d.data[0]
+	d.data = d.data[1:]
+	return b
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.length()
+	b := d.data[:n:n]
+	d.data = d.data[n:]
+	return b
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
+
+func (d *decoder) strings() []string {
+	s := make([]string, d.length())
+	for i := range s {
+		s[i] = d.string()
+	}
+	return s
+}
+
+func (d *decoder) constant() object.Object {
+	switch tag := d.byte(); tag {
+	case tagInteger:
+		if d.err != nil {
+			return nil
+		}
+		n, size := binary.Varint(d.data)
+		if size <= 0 {
+			d.fail("malformed integer")
+			return nil
+		}
+		d.data = d.data[size:]
+		return &object.Integer{Value: n}
+	case tagFloat:
+		if len(d.data) < 8 {
+			d.fail("unexpected end of data")
+			return nil
+		}
+		bits := binary.BigEndian.Uint64(d.data)
+		d.data = d.data[8:]
+		return &object.Float{Value: math.Float64frombits(bits)}
+	case tagString:
+		return &object.String{Value: d.string()}
+	case tagFunction:
+		fn := &object.CompiledFunction{Name: d.string()}
+		fn.Parameters = d.strings()
+		fn.Locals = d.strings()
+		fn.Free = d.strings()
+		fn.NumLocals = d.uint()
+		fn.NumParameters = d.uint()
+		fn.Instructions = d.bytes()
+		fn.SourceMap = d.sourceMap()
+		return fn
+	default:
+		d.fail("unknown constant tag %d", tag)
+		return nil
+	}
+}
+
+func (d *decoder) sourceMap() code.SourceMap {
+	n := d.length()
+	if n == 0 {
+		return nil
+	}
+	sm := make(code.SourceMap, n)
+	for i := range sm {
+		sm[i].Offset = d.uint()
+		sm[i].Pos = token.Position{
+			Filename: d.string(),
+			Offset:   d.uint(),
+			Line:     d.uint(),
+			Column:   d.uint(),
+		}
+	}
+	return sm
+}
@@ -0,0 +1,153 @@
+package compiler
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mikeraimondi/monkey/code"
+	"github.com/mikeraimondi/monkey/object"
+)
+
+func TestBytecodeRoundTrip(t *testing.T) {
+	input := `
+	let add = fn(a, b) { a + b };
+	let greet = fn() { "hello" };
+	let mk = fn(x) { let y = x; fn() { y } };
+	add(1, -200000) * 2.5;
+	`
+
+	comp := New()
+	err := comp.Compile(parse(t, input))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	original := comp.Bytecode()
+
+	data, err := original.MarshalBinary()
+	if err != nil {
+		t.Fatalf("marshal error: %s", err)
+	}
+
+	decoded := &Bytecode{}
+	err = decoded.UnmarshalBinary(data)
+	if err != nil {
+		t.Fatalf("unmarshal error: %s", err)
+	}
+
+	if !reflect.DeepEqual(decoded, original) {
+		t.Errorf("wrong bytecode.\nexpected %+v\ngot %+v", original, decoded)
+	}
+}
+
+func TestUnmarshalInvalidBytecode(t *testing.T) {
+	valid := &Bytecode{
+		Instructions: concatInstructions([]code.Instructions{
+			code.Make(code.OpConstant, 0),
+			code.Make(code.OpPop),
+		}),
+		Constants: []object.Object{&object.Integer{Value: 1}},
+	}
+	data, err := valid.MarshalBinary()
+	if err != nil {
+		t.Fatalf("marshal error: %s", err)
+	}
+
+	tests := []struct {
+		name     string
+		bytecode *Bytecode
+		data     []byte
+		expected string
+	}{
+		{
+			name:     "no header",
+			data:     []byte("let x = 1;"),
+			expected: "invalid bytecode: missing header",
+		},
+		{
+			name:     "other version",
+			data:     append([]byte(BytecodeMagic), BytecodeVersion+1),
+			expected: "unsupported bytecode version 6, want 5",
+		},
+		{
+			name:     "truncated",
+			data:     data[:len(data)-3],
+			expected: "invalid bytecode: unexpected end of data",
+		},
+		{
+			name:     "trailing data",
+			data:     append(append([]byte{}, data...), 0),
+			expected: "invalid bytecode: 1 bytes of trailing data",
+		},
+		{
+			name: "unknown opcode",
+			bytecode: &Bytecode{
+				Instructions: code.Instructions{255},
+			},
+			expected: "invalid bytecode: opcode 255 undefinied at 0",
+		},
+		{
+			name: "truncated instruction",
+			bytecode: &Bytecode{
+				Instructions: code.Make(code.OpConstant, 0)[:2],
+				Constants:    []object.Object{&object.Integer{Value: 1}},
+			},
+			expected: "invalid bytecode: truncated OpConstant at 0",
+		},
+		{
+			name: "constant out of range",
+			bytecode: &Bytecode{
+				Instructions: code.Make(code.OpConstant, 1),
+				Constants:    []object.Object{&object.Integer{Value: 1}},
+			},
+			expected: "invalid bytecode: constant 1 out of range at 0",
+		},
+		{
+			name: "closure of non-function",
+			bytecode: &Bytecode{
+				Instructions: code.Make(code.OpClosure, 0, 0),
+				Constants:    []object.Object{&object.String{Value: "f"}},
+			},
+			expected: "invalid bytecode: constant 0 is not a function at 0",
+		},
+		{
+			name: "bad function",
+			bytecode: &Bytecode{
+				Constants: []object.Object{
+					&object.CompiledFunction{Instructions: code.Make(code.OpGetLocal, 0)[:1]},
+				},
+			},
+			expected: "invalid bytecode: constant 0: truncated OpGetLocal at 0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := tt.data
+			if tt.bytecode != nil {
+				var err error
+				data, err = tt.bytecode.MarshalBinary()
+				if err != nil {
+					t.Fatalf("marshal error: %s", err)
+				}
+			}
+
+			err := (&Bytecode{}).UnmarshalBinary(data)
+			if err == nil {
+				t.Fatalf("expected error, got none")
+			}
+			if err.Error() != tt.expected {
+				t.Errorf("wrong error. expected %q, got %q", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestMarshalUnsupportedConstant(t *testing.T) {
+	b := &Bytecode{Constants: []object.Object{&object.Boolean{Value: true}}}
+
+	_, err := b.MarshalBinary()
+	if err == nil || !strings.Contains(err.Error(), "cannot serialize BOOLEAN") {
+		t.Errorf("wrong error. got %v", err)
+	}
+}
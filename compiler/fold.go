@@ -0,0 +1,211 @@
+package compiler
+
+import (
+	"math"
+
+	"github.com/mikeraimondi/monkey/ast"
+	"github.com/mikeraimondi/monkey/code"
+	"github.com/mikeraimondi/monkey/object"
+)
+
+// emitFolded emits the value of node and reports true if it can be computed
+// while compiling
+func (c *Compiler) emitFolded(node ast.Expression) bool {
+	switch value := fold(node).(type) {
+	case nil:
+		return false
+	case *object.Boolean:
+		if value.Value {
+			c.emit(code.OpTrue)
+		} else {
+			c.emit(code.OpFalse)
+		}
+	default:
+		c.emit(code.OpConstant, c.addConstant(value))
+	}
+	return true
+}
+
+// fold computes the value of an expression made only of literals, as the VM
+// would. It returns nil if the expression has other parts, or if computing
+// it would fail, so that the error is left to run time.
+func fold(node ast.Expression) object.Object {
+	switch node := node.(type) {
+	case *ast.IntegerLiteral:
+		return &object.Integer{Value: node.Value}
+	case *ast.FloatLiteral:
+		return &object.Float{Value: node.Value}
+	case *ast.StringLiteral:
+		return &object.String{Value: node.Value}
+	case *ast.Boolean:
+		return &object.Boolean{Value: node.Value}
+	case *ast.PrefixExpression:
+		right := fold(node.Right)
+		if right == nil {
+			return nil
+		}
+		return foldPrefix(node.Operator, right)
+	case *ast.InfixExpression:
+		left := fold(node.Left)
+		if left == nil {
+			return nil
+		}
+		right := fold(node.Right)
+		if right == nil {
+			return nil
+		}
+		return foldInfix(node.Operator, left, right)
+	}
+	return nil
+}
+
+func foldPrefix(operator string, right object.Object) object.Object {
+	switch operator {
+	case "!":
+		if b, ok := right.(*object.Boolean); ok {
+			return &object.Boolean{Value: !b.Value}
+		}
+		return &object.Boolean{Value: false}
+	case "-":
+		switch right := right.(type) {
+		case *object.Integer:
+			return &object.Integer{Value: -right.Value}
+		case *object.Float:
+			return &object.Float{Value: -right.Value}
+		}
+	}
+	return nil
+}
+
+func foldInfix(operator string, left, right object.Object) object.Object {
+	switch operator {
+	case "&&":
+		if isTruthy(left) {
+			return right
+		}
+		return left
+	case "||":
+		if isTruthy(left) {
+			return left
+		}
+		return right
+	}
+
+	leftInt, leftIsInt := left.(*object.Integer)
+	rightInt, rightIsInt := right.(*object.Integer)
+	if leftIsInt && rightIsInt {
+		return foldIntegers(operator, leftInt.Value, rightInt.Value)
+	}
+
+	leftNum, leftIsNum := toFloat(left)
+	rightNum, rightIsNum := toFloat(right)
+	if leftIsNum && rightIsNum {
+		return foldFloats(operator, leftNum, rightNum)
+	}
+
+	switch left := left.(type) {
+	case *object.String:
+		if right, ok := right.(*object.String); ok {
+			return foldStrings(operator, left.Value, right.Value)
+		}
+	case *object.Boolean:
+		if right, ok := right.(*object.Boolean); ok {
+			switch operator {
+			case "==":
+				return &object.Boolean{Value: left.Value == right.Value}
+			case "!=":
+				return &object.Boolean{Value: left.Value != right.Value}
+			}
+		}
+	}
+	return nil
+}
+
+func foldIntegers(operator string, left, right int64) object.Object {
+	switch operator {
+	case "+":
+		return &object.Integer{Value: left + right}
+	case "-":
+		return &object.Integer{Value: left - right}
+	case "*":
+		return &object.Integer{Value: left * right}
+	case "/":
+		if right != 0 {
+			return &object.Integer{Value: left / right}
+		}
+	case "%":
+		if right != 0 {
+			return &object.Integer{Value: left % right}
+		}
+	case "==":
+		return &object.Boolean{Value: left == right}
+	case "!=":
+		return &object.Boolean{Value: left != right}
+	case ">":
+		return &object.Boolean{Value: left > right}
+	case ">=":
+		return &object.Boolean{Value: left >= right}
+	case "<":
+		return &object.Boolean{Value: left < right}
+	case "<=":
+		return &object.Boolean{Value: left <= right}
+	}
+	return nil
+}
+
+func foldFloats(operator string, left, right float64) object.Object {
+	switch operator {
+	case "+":
+		return &object.Float{Value: left + right}
+	case "-":
+		return &object.Float{Value: left - right}
+	case "*":
+		return &object.Float{Value: left * right}
+	case "/":
+		return &object.Float{Value: left / right}
+	case "%":
+		return &object.Float{Value: math.Mod(left, right)}
+	case "==":
+		return &object.Boolean{Value: left == right}
+	case "!=":
+		return &object.Boolean{Value: left != right}
+	case ">":
+		return &object.Boolean{Value: left > right}
+	case ">=":
+		return &object.Boolean{Value: left >= right}
+	case "<":
+		return &object.Boolean{Value: left < right}
+	case "<=":
+		return &object.Boolean{Value: left <= right}
+	}
+	return nil
+}
+
+func foldStrings(operator string, left, right string) object.Object {
+	switch operator {
+	case "+":
+		return &object.String{Value: left + right}
+	case "==":
+		return &object.Boolean{Value: left == right}
+	case "!=":
+		return &object.Boolean{Value: left != right}
+	}
+	return nil
+}
+
+func toFloat(obj object.Object) (float64, bool) {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return float64(obj.Value), true
+	case *object.Float:
+		return obj.Value, true
+	}
+	return 0, false
+}
+
+func isTruthy(obj object.Object) bool {
+	if b, ok := obj.(*object.Boolean); ok {
+		return b.Value
+	}
+	return true
+}
@@ -0,0 +1,355 @@
+package compiler
+
+import (
+	"github.com/mikeraimondi/monkey/code"
+	"github.com/mikeraimondi/monkey/token"
+)
+
+// instruction is a decoded instruction being optimized. The first operand
+// of a jump is the index of the instruction it jumps to, rather than its
+// offset, so that instructions can be removed without breaking jumps.
+type instruction struct {
+	op       code.Opcode
+	operands []int
+	pos      token.Position
+	removed  bool
+}
+
+// peepholes rewrite a list of instructions, marking those they remove, and
+// report whether they changed anything. isMain is whether the instructions
+// are the main program's.
+var peepholes = []func(list []instruction, isMain bool) bool{
+	removeUnreachable,
+	threadJumps,
+	removeJumpsToNext,
+	removeUnusedValues,
+	foldConstantConditions,
+}
+
+// optimize replaces common sequences in the instructions of the current
+// scope with superinstructions, after applying the peepholes until none
+// applies if the compiler is optimizing, then reassembles them with their
+// jumps and source positions relocated
+func (c *Compiler) optimize(isMain bool) {
+	scope := &c.scopes[c.scopeIndex]
+	list := decodeInstructions(scope.instructions, scope.sourceMap)
+
+	for changed := c.optimizing; changed; {
+		changed = false
+		for _, peephole := range peepholes {
+			if peephole(list, isMain) {
+				list = compact(list)
+				changed = true
+			}
+		}
+	}
+	list = specialize(list)
+	if c.optimizing {
+		list = fuseConstantComparisons(list)
+	}
+
+	offsets := make([]int, len(list)+1)
+	for i, in := range list {
+		def, _ := code.Lookup(byte(in.op))
+		offsets[i+1] = offsets[i] + 1 + def.Width()
+	}
+
+	scope.instructions = code.Instructions{}
+	scope.sourceMap = nil
+	scope.lastInstruction = EmittedInstruction{}
+	scope.previousInstruction = EmittedInstruction{}
+
+	outer := c.pos
+	for _, in := range list {
+		if code.IsJump(in.op) {
+			in.operands[0] = offsets[in.operands[0]]
+		}
+		c.pos = in.pos
+		c.emit(in.op, in.operands...)
+	}
+	c.pos = outer
+}
+
+// specialize replaces sequences of instructions with superinstructions doing
+// the same in one dispatch. A superinstruction takes the place of the first
+// instruction of its sequence, so the others must not be jumped to.
+func specialize(list []instruction) []instruction {
+	targets := jumpTargets(list)
+	for i := 0; i+1 < len(list); i++ {
+		in, next := &list[i], &list[i+1]
+		if targets[i+1] {
+			continue
+		}
+
+		// errors are reported where the operator is, which is the second
+		// instruction when adding and the first when comparing
+		switch {
+		case in.op == code.OpConstant && next.op == code.OpAdd:
+			in.op = code.OpAddConst
+			in.pos = next.pos
+		case in.op == code.OpConstant && next.op == code.OpSub:
+			in.op = code.OpSubConst
+			in.pos = next.pos
+		case isComparison(in.op) && next.op == code.OpJumpNotTruthy:
+			in.operands = []int{next.operands[0], int(in.op)}
+			in.op = code.OpCompareJump
+		default:
+			continue
+		}
+		next.removed = true
+		i++
+	}
+	list = compact(list)
+
+	targets = jumpTargets(list)
+	for i := 0; i+2 < len(list); i++ {
+		get, add, set := &list[i], &list[i+1], &list[i+2]
+		if get.op == code.OpGetLocal && add.op == code.OpAddConst &&
+			set.op == code.OpSetLocal && get.operands[0] == set.operands[0] &&
+			!targets[i+1] && !targets[i+2] {
+			get.op = code.OpIncrLocal
+			get.operands = []int{get.operands[0], add.operands[0]}
+			get.pos = add.pos
+			add.removed = true
+			set.removed = true
+			i += 2
+		}
+	}
+	list = compact(list)
+
+	for i := range list {
+		if list[i].op == code.OpGetLocal && list[i].operands[0] <= 3 {
+			list[i].op = code.OpGetLocal0 + code.Opcode(list[i].operands[0])
+			list[i].operands = nil
+		}
+	}
+	return list
+}
+
+// fuseConstantComparisons replaces a constant followed by a comparison and
+// jump with a single OpCompareConstJump, which saves a dispatch on tests
+// such as the base cases of recursive functions
+func fuseConstantComparisons(list []instruction) []instruction {
+	targets := jumpTargets(list)
+	for i := 0; i+1 < len(list); i++ {
+		constant, compare := &list[i], &list[i+1]
+		if constant.op != code.OpConstant || compare.op != code.OpCompareJump || targets[i+1] {
+			continue
+		}
+		constant.op = code.OpCompareConstJump
+		constant.operands = []int{compare.operands[0], constant.operands[0], compare.operands[1]}
+		constant.pos = compare.pos
+		compare.removed = true
+		i++
+	}
+	return compact(list)
+}
+
+func decodeInstructions(ins code.Instructions, sm code.SourceMap) []instruction {
+	var list []instruction
+	index := map[int]int{}
+
+	for ip := 0; ip < len(ins); {
+		def, _ := code.Lookup(ins[ip])
+		operands, read := code.ReadOperands(def, ins[ip+1:])
+		pos, _ := sm.Lookup(ip)
+
+		index[ip] = len(list)
+		list = append(list, instruction{op: code.Opcode(ins[ip]), operands: operands, pos: pos})
+		ip += 1 + read
+	}
+	index[len(ins)] = len(list)
+
+	for _, in := range list {
+		if code.IsJump(in.op) {
+			in.operands[0] = index[in.operands[0]]
+		}
+	}
+	return list
+}
+
+// compact drops the removed instructions from list. Jumps to a removed
+// instruction go to the next one kept instead.
+func compact(list []instruction) []instruction {
+	index := make([]int, len(list)+1)
+	kept := 0
+	for i, in := range list {
+		index[i] = kept
+		if !in.removed {
+			kept++
+		}
+	}
+	index[len(list)] = kept
+
+	out := list[:0]
+	for _, in := range list {
+		if in.removed {
+			continue
+		}
+		if code.IsJump(in.op) {
+			in.operands[0] = index[in.operands[0]]
+		}
+		out = append(out, in)
+	}
+	return out
+}
+
+// jumpTargets reports which instructions of list are jumped to
+func jumpTargets(list []instruction) []bool {
+	targets := make([]bool, len(list)+1)
+	for _, in := range list {
+		if code.IsJump(in.op) {
+			targets[in.operands[0]] = true
+		}
+	}
+	return targets
+}
+
+// removeUnreachable removes the instructions no path leads to, such as
+// those following a return
+func removeUnreachable(list []instruction, isMain bool) bool {
+	reachable := make([]bool, len(list)+1)
+	work := []int{0}
+	for len(work) > 0 {
+		i := work[len(work)-1]
+		work = work[:len(work)-1]
+		if reachable[i] {
+			continue
+		}
+		reachable[i] = true
+		if i == len(list) {
+			continue
+		}
+
+		if code.IsJump(list[i].op) {
+			work = append(work, list[i].operands[0])
+		}
+		switch list[i].op {
+		case code.OpJump, code.OpReturnValue, code.OpReturn:
+		default:
+			work = append(work, i+1)
+		}
+	}
+
+	changed := false
+	for i := range list {
+		if !reachable[i] {
+			list[i].removed = true
+			changed = true
+		}
+	}
+	return changed
+}
+
+// threadJumps makes jumps to unconditional jumps go straight to where those
+// lead, and turns unconditional jumps to a return into the return
+func threadJumps(list []instruction, isMain bool) bool {
+	changed := false
+	for i := range list {
+		in := &list[i]
+		if !code.IsJump(in.op) {
+			continue
+		}
+
+		// stop at the first jump seen twice, in case they form a cycle
+		target := in.operands[0]
+		seen := map[int]bool{}
+		for target < len(list) && list[target].op == code.OpJump && !seen[target] {
+			seen[target] = true
+			target = list[target].operands[0]
+		}
+		if target != in.operands[0] {
+			in.operands[0] = target
+			changed = true
+		}
+
+		if in.op == code.OpJump && target < len(list) {
+			switch list[target].op {
+			case code.OpReturnValue, code.OpReturn:
+				in.op, in.operands = list[target].op, nil
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// removeJumpsToNext removes unconditional jumps to the instruction that
+// follows them
+func removeJumpsToNext(list []instruction, isMain bool) bool {
+	changed := false
+	for i := range list {
+		if list[i].op == code.OpJump && list[i].operands[0] == i+1 {
+			list[i].removed = true
+			changed = true
+		}
+	}
+	return changed
+}
+
+// removeUnusedValues removes values that are pushed without side effects
+// and popped straight away, unless the pop is jumped to. The last pop of the
+// main program is kept, as it leaves the program's result.
+func removeUnusedValues(list []instruction, isMain bool) bool {
+	targets := jumpTargets(list)
+	end := len(list)
+	if isMain {
+		end--
+	}
+
+	changed := false
+	for i := 0; i+1 < end; i++ {
+		if isPure(list[i].op) && list[i+1].op == code.OpPop && !targets[i+1] {
+			list[i].removed = true
+			list[i+1].removed = true
+			changed = true
+			i++
+		}
+	}
+	return changed
+}
+
+// foldConstantConditions removes conditional jumps on true and makes those
+// on false or null unconditional, unless they are jumped to
+func foldConstantConditions(list []instruction, isMain bool) bool {
+	targets := jumpTargets(list)
+
+	changed := false
+	for i := 0; i+1 < len(list); i++ {
+		if list[i+1].op != code.OpJumpNotTruthy || targets[i+1] {
+			continue
+		}
+		switch list[i].op {
+		case code.OpTrue:
+			list[i+1].removed = true
+		case code.OpFalse, code.OpNull:
+			list[i+1].op = code.OpJump
+		default:
+			continue
+		}
+		list[i].removed = true
+		changed = true
+		i++
+	}
+	return changed
+}
+
+// isComparison reports whether op compares the top two values of the stack
+func isComparison(op code.Opcode) bool {
+	switch op {
+	case code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpGreaterThanOrEqual:
+		return true
+	}
+	return false
+}
+
+// isPure reports whether op only pushes a value, without side effects
+func isPure(op code.Opcode) bool {
+	switch op {
+	case code.OpConstant, code.OpTrue, code.OpFalse, code.OpNull,
+		code.OpGetGlobal, code.OpGetLocal, code.OpGetFree, code.OpGetBuiltin,
+		code.OpCurrentClosure:
+		return true
+	}
+	return false
+}
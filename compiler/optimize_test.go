@@ -0,0 +1,249 @@
+package compiler
+
+import (
+	"testing"
+
+	"github.com/mikeraimondi/monkey/code"
+)
+
+func TestOptimization(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			// the last value of the program is its result
+			input:             "1; 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "fn() { return 1; 2 }",
+			expectedConstants: []interface{}{
+				1,
+				2,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "fn(x) { if (x) { 1 } else { 2 } }",
+			expectedConstants: []interface{}{
+				1,
+				2,
+				[]code.Instructions{
+					// 0000
+					code.Make(code.OpGetLocal0),
+					// 0001
+					code.Make(code.OpJumpNotTruthy, 8),
+					// 0004
+					code.Make(code.OpConstant, 0),
+					// 0007
+					code.Make(code.OpReturnValue),
+					// 0008
+					code.Make(code.OpConstant, 1),
+					// 0011
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "if (false) { 1 }; 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "while (true) { 1 }",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpJump, 0),
+			},
+		},
+		{
+			input:             "let x = 1; while (x) { if (x) { break; } x; }",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpSetGlobal, 0),
+				// 0006
+				code.Make(code.OpGetGlobal, 0),
+				// 0009
+				code.Make(code.OpJumpNotTruthy, 18),
+				// 0012
+				code.Make(code.OpGetGlobal, 0),
+				// 0015
+				code.Make(code.OpJumpNotTruthy, 6),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests, WithOptimization())
+}
+
+func TestOptimizedSourceMap(t *testing.T) {
+	input := "1;\n2;\nlet x = 3;"
+
+	comp := New(WithOptimization())
+	err := comp.Compile(parse(t, input))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := comp.Bytecode()
+
+	// the instructions of the first two lines are removed
+	tests := []struct {
+		offset       int
+		expectedLine int
+	}{
+		{0, 3},
+		{3, 3},
+	}
+
+	for _, tt := range tests {
+		pos, ok := bytecode.SourceMap.Lookup(tt.offset)
+		if !ok {
+			t.Fatalf("no position for offset %d", tt.offset)
+		}
+		if pos.Line != tt.expectedLine {
+			t.Errorf("wrong line for offset %d. expected %d, got %d",
+				tt.offset, tt.expectedLine, pos.Line)
+		}
+	}
+}
+
+func TestSuperinstructions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: "fn(x) { x + 1 }",
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpGetLocal0),
+					code.Make(code.OpAddConst, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// the constant subtracted is shared with the one added
+			input: "fn(x) { x - 1 + 1 }",
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpGetLocal0),
+					code.Make(code.OpSubConst, 0),
+					code.Make(code.OpAddConst, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "fn(a, b, c, d, e) { e; d }",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetLocal3),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "fn(a, b, c, d, e) { e }",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 4),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "fn(n) { let i = 0; while (i < n) { i = i + 1; } i }",
+			expectedConstants: []interface{}{
+				0,
+				1,
+				[]code.Instructions{
+					// 0000
+					code.Make(code.OpConstant, 0),
+					// 0003
+					code.Make(code.OpSetLocal, 1),
+					// 0005
+					code.Make(code.OpGetLocal0),
+					// 0006
+					code.Make(code.OpGetLocal1),
+					// 0007
+					code.Make(code.OpCompareJump, 18, int(code.OpGreaterThan)),
+					// 0011
+					code.Make(code.OpIncrLocal, 1, 1),
+					// 0015
+					code.Make(code.OpJump, 5),
+					// 0018
+					code.Make(code.OpGetLocal1),
+					// 0019
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "fn(x) { if (x == 0) { 1 } else { 2 } }",
+			expectedConstants: []interface{}{
+				0,
+				1,
+				2,
+				[]code.Instructions{
+					// 0000
+					code.Make(code.OpGetLocal0),
+					// 0001
+					code.Make(code.OpCompareConstJump, 11, 0, int(code.OpEqual)),
+					// 0007
+					code.Make(code.OpConstant, 1),
+					// 0010
+					code.Make(code.OpReturnValue),
+					// 0011
+					code.Make(code.OpConstant, 2),
+					// 0014
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 3, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests, WithOptimization())
+}
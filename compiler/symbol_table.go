@@ -1,5 +1,7 @@
 package compiler
 
+import "sort"
+
 type SymbolScope string
 
 const (
@@ -7,6 +9,9 @@ const (
 	LocalScope   SymbolScope = "LOCAL"
 	BuiltinScope SymbolScope = "BUILTIN"
 	FreeScope    SymbolScope = "FREE"
+	// FunctionScope is the name of the function being compiled, which refers
+	// to the closure currently executing
+	FunctionScope SymbolScope = "FUNCTION"
 )
 
 type Symbol struct {
@@ -21,6 +26,9 @@ type SymbolTable struct {
 
 	store          map[string]Symbol
 	numDefinitions int
+	// names holds the name of each symbol defined, by index, including
+	// those shadowed by later definitions
+	names []string
 }
 
 func NewSymbolTable() *SymbolTable {
@@ -64,6 +72,7 @@ func (s *SymbolTable) Define(name string) Symbol {
 
 	s.store[name] = symbol
 	s.numDefinitions++
+	s.names = append(s.names, name)
 	return symbol
 }
 
@@ -73,6 +82,14 @@ func (s *SymbolTable) DefineBuiltin(index int, name string) Symbol {
 	return symbol
 }
 
+// DefineFunctionName defines the name of the function whose body the table is
+// for, so that the function can call itself without capturing a variable
+func (s *SymbolTable) DefineFunctionName(name string) Symbol {
+	symbol := Symbol{Name: name, Index: 0, Scope: FunctionScope}
+	s.store[name] = symbol
+	return symbol
+}
+
 func (s *SymbolTable) defineFree(original Symbol) Symbol {
 	s.FreeSymbols = append(s.FreeSymbols, original)
 
@@ -82,3 +99,39 @@ func (s *SymbolTable) defineFree(original Symbol) Symbol {
 	s.store[original.Name] = symbol
 	return symbol
 }
+
+// Names returns the names of the symbols defined in this table, by index
+func (s *SymbolTable) Names() []string {
+	return append([]string{}, s.names...)
+}
+
+// Symbols returns the symbols defined in this table, ordered by scope and index
+func (s *SymbolTable) Symbols() []Symbol {
+	symbols := make([]Symbol, 0, len(s.store))
+	for _, symbol := range s.store {
+		symbols = append(symbols, symbol)
+	}
+
+	sort.Slice(symbols, func(i, j int) bool {
+		if symbols[i].Scope != symbols[j].Scope {
+			return symbols[i].Scope < symbols[j].Scope
+		}
+		return symbols[i].Index < symbols[j].Index
+	})
+
+	return symbols
+}
+
+// Copy returns a copy of the table that can be defined into without
+// affecting the original. Outer tables are shared.
+func (s *SymbolTable) Copy() *SymbolTable {
+	c := NewSymbolTable()
+	c.Outer = s.Outer
+	c.FreeSymbols = append(c.FreeSymbols, s.FreeSymbols...)
+	c.numDefinitions = s.numDefinitions
+	c.names = append(c.names, s.names...)
+	for name, symbol := range s.store {
+		c.store[name] = symbol
+	}
+	return c
+}
@@ -254,6 +254,41 @@ func TestResolveFree(t *testing.T) {
 	}
 }
 
+func TestDefineAndResolveFunctionName(t *testing.T) {
+	global := NewSymbolTable()
+	global.DefineFunctionName("a")
+
+	expected := Symbol{Name: "a", Scope: FunctionScope, Index: 0}
+
+	result, ok := global.Resolve(expected.Name)
+	if !ok {
+		t.Fatalf("function name %s not resolvable", expected.Name)
+	}
+
+	if result != expected {
+		t.Errorf("expected %s to resolve to %+v, got %+v",
+			expected.Name, expected, result)
+	}
+}
+
+func TestShadowingFunctionName(t *testing.T) {
+	global := NewSymbolTable()
+	global.DefineFunctionName("a")
+	global.Define("a")
+
+	expected := Symbol{Name: "a", Scope: GlobalScope, Index: 0}
+
+	result, ok := global.Resolve(expected.Name)
+	if !ok {
+		t.Fatalf("function name %s not resolvable", expected.Name)
+	}
+
+	if result != expected {
+		t.Errorf("expected %s to resolve to %+v, got %+v",
+			expected.Name, expected, result)
+	}
+}
+
 func TestResolveUnresolvableFree(t *testing.T) {
 	global := NewSymbolTable()
 	global.Define("a")
@@ -295,3 +330,29 @@ func TestResolveUnresolvableFree(t *testing.T) {
 		}
 	}
 }
+
+func TestCopy(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+
+	copied := global.Copy()
+	copied.Define("b")
+
+	if _, ok := global.Resolve("b"); ok {
+		t.Errorf("defining in copy changed original")
+	}
+
+	expected := []Symbol{
+		{Name: "a", Scope: GlobalScope, Index: 0},
+		{Name: "b", Scope: GlobalScope, Index: 1},
+	}
+	symbols := copied.Symbols()
+	if len(symbols) != len(expected) {
+		t.Fatalf("wrong number of symbols. expected %d, got %d", len(expected), len(symbols))
+	}
+	for i, sym := range expected {
+		if symbols[i] != sym {
+			t.Errorf("wrong symbol %d. expected %+v, got %+v", i, sym, symbols[i])
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/mikeraimondi/monkey/compiler"
+)
+
+const disasmUsage = `usage: monkey disasm [-O] file
+
+Disasm prints the bytecode for the program in file, which may be source or
+bytecode compiled by "monkey build". With -O, source is compiled with
+optimization.
+
+Compiled bytecode doesn't keep the names of globals, so in its listing only
+args is named and other globals are shown by index alone.
+
+Flags:
+`
+
+// disasmCommand implements "monkey disasm" with the arguments following it
+func disasmCommand(args []string) int {
+	flags := flag.NewFlagSet("disasm", flag.ContinueOnError)
+	optimize := flags.Bool("O", false, "optimize the bytecode")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), disasmUsage)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	name := flags.Arg(0)
+	src, err := ioutil.ReadFile(name)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+
+	var options []compiler.Option
+	if *optimize {
+		options = append(options, compiler.WithOptimization())
+	}
+	return disasm(name, src, os.Stdout, os.Stderr, options...)
+}
+
+// disasm writes the listing of the program in src, read from the file name,
+// to out, compiling source with options. Errors are written to stderr. It
+// returns the exit code for the process.
+func disasm(name string, src []byte, out, stderr io.Writer, options ...compiler.Option) int {
+	var code *compiler.Bytecode
+	var globals *compiler.SymbolTable
+
+	if compiler.IsBytecode(src) {
+		code = &compiler.Bytecode{}
+		err := code.UnmarshalBinary(src)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return exitFailure
+		}
+		// the names of other globals are not kept in bytecode
+		globals = compiler.NewSymbolTable()
+		globals.Define("args")
+	} else {
+		var ok bool
+		code, globals, ok = compile(name, string(src), stderr, options...)
+		if !ok {
+			return exitFailure
+		}
+	}
+
+	fmt.Fprint(out, compiler.Disassemble(code, globals))
+	return exitOK
+}
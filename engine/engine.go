@@ -0,0 +1,424 @@
+package engine
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mikeraimondi/monkey/ast"
+	"github.com/mikeraimondi/monkey/code"
+	"github.com/mikeraimondi/monkey/compiler"
+	"github.com/mikeraimondi/monkey/evaluator"
+	"github.com/mikeraimondi/monkey/object"
+	"github.com/mikeraimondi/monkey/vm"
+)
+
+// Engine executes Monkey programs. Global bindings persist from one Run to
+// the next.
+type Engine interface {
+	// Run executes program and returns the value of its final expression
+	// statement. The value is nil if the program does not end with one.
+	Run(program *ast.Program) (object.Object, error)
+	// Define binds name to value in the global scope
+	Define(name string, value object.Object)
+	// Globals returns the global bindings, sorted by name
+	Globals() []Binding
+	// Reset discards all global bindings
+	Reset()
+}
+
+// Binding is a global name and its value
+type Binding struct {
+	Name  string
+	Value object.Object
+}
+
+// Names lists the engines accepted by New
+var Names = []string{"vm", "eval", "diff"}
+
+// New returns the engine with the given name
+func New(name string) (Engine, error) {
+	switch name {
+	case "vm":
+		return NewVM(), nil
+	case "eval":
+		return NewEvaluator(), nil
+	case "diff":
+		return NewDifferential(), nil
+	default:
+		return nil, fmt.Errorf("unknown engine %q, want one of %s",
+			name, strings.Join(Names, ", "))
+	}
+}
+
+// Phase is the stage of execution in which an Error occurred
+type Phase string
+
+const (
+	// Compilation is compiling an AST to bytecode
+	Compilation Phase = "compilation"
+	// Execution is running bytecode or evaluating an AST
+	Execution Phase = "execution"
+	// Verification is checking bytecode compiled elsewhere before running it
+	Verification Phase = "verification"
+)
+
+// Error is an error from one phase of running a program
+type Error struct {
+	Phase Phase
+	Err   error
+}
+
+func (e *Error) Error() string {
+	return string(e.Phase) + " failure: " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// VM compiles programs to bytecode and runs them on the virtual machine
+type VM struct {
+	SymbolTable *compiler.SymbolTable
+	Constants   []object.Object
+	GlobalStore []object.Object
+
+	// Options configure each virtual machine the engine runs, e.g. its limits
+	Options []vm.Option
+	// CompilerOptions configure the compiler, e.g. to optimize programs
+	CompilerOptions []compiler.Option
+}
+
+// NewVM returns a VM engine with the builtins defined
+func NewVM() *VM {
+	e := &VM{}
+	e.Reset()
+	return e
+}
+
+// Reset discards the globals and constants
+func (e *VM) Reset() {
+	symbolTable := compiler.NewSymbolTable()
+	for i, v := range object.Builtins {
+		symbolTable.DefineBuiltin(i, v.Name)
+	}
+
+	e.SymbolTable = symbolTable
+	e.Constants = []object.Object{}
+	e.GlobalStore = make([]object.Object, vm.GlobalsSize)
+}
+
+// Compile compiles program against the engine's globals and constants,
+// without changing them
+func (e *VM) Compile(program *ast.Program) (*compiler.Bytecode, error) {
+	n := len(e.Constants)
+	comp := compiler.NewWithState(e.SymbolTable.Copy(), e.Constants[:n:n], e.CompilerOptions...)
+	err := comp.Compile(program)
+	if err != nil {
+		return nil, &Error{Phase: Compilation, Err: err}
+	}
+	return comp.Bytecode(), nil
+}
+
+// Run compiles and executes program
+func (e *VM) Run(program *ast.Program) (object.Object, error) {
+	// the globals the program defines are kept only if it compiles
+	symbolTable := e.SymbolTable.Copy()
+	comp := compiler.NewWithState(symbolTable, e.Constants, e.CompilerOptions...)
+	err := comp.Compile(program)
+	if err != nil {
+		return nil, &Error{Phase: Compilation, Err: err}
+	}
+
+	code := comp.Bytecode()
+	e.SymbolTable = symbolTable
+	e.Constants = code.Constants
+
+	return e.execute(code, endsWithExpression(program))
+}
+
+// RunBytecode verifies and executes bytecode compiled in advance, e.g. read
+// from a .mkc file. The globals it refers to must have been defined in the
+// same order as they were when it was compiled.
+func (e *VM) RunBytecode(code *compiler.Bytecode) (object.Object, error) {
+	err := vm.Verify(code)
+	if err != nil {
+		return nil, &Error{Phase: Verification, Err: err}
+	}
+	return e.execute(code, endsWithPop(code.Instructions))
+}
+
+// execute runs code on a virtual machine sharing the engine's globals and
+// returns the value last popped if hasResult is set
+func (e *VM) execute(code *compiler.Bytecode, hasResult bool) (object.Object, error) {
+	options := append([]vm.Option{vm.WithGlobals(e.GlobalStore)}, e.Options...)
+	machine := vm.New(code, options...)
+	err := machine.Run()
+	if err != nil {
+		var undefined *vm.UndefinedGlobalError
+		if errors.As(err, &undefined) {
+			if names := e.SymbolTable.Names(); undefined.Index < len(names) {
+				undefined.Name = names[undefined.Index]
+			}
+		}
+		return nil, &Error{Phase: Execution, Err: err}
+	}
+
+	if !hasResult {
+		return nil, nil
+	}
+	return machine.LastPoppedStackElem(), nil
+}
+
+// Define binds name to value as a global
+func (e *VM) Define(name string, value object.Object) {
+	symbol := e.SymbolTable.Define(name)
+	e.GlobalStore[symbol.Index] = value
+}
+
+// Globals returns the values of the global symbols
+func (e *VM) Globals() []Binding {
+	bindings := []Binding{}
+	for _, symbol := range e.SymbolTable.Symbols() {
+		if symbol.Scope == compiler.GlobalScope {
+			bindings = append(bindings, Binding{symbol.Name, e.GlobalStore[symbol.Index]})
+		}
+	}
+	sortBindings(bindings)
+	return bindings
+}
+
+// Evaluator walks the AST with the tree-walking evaluator
+type Evaluator struct {
+	Env *object.Environment
+}
+
+// NewEvaluator returns an Evaluator engine with an empty environment
+func NewEvaluator() *Evaluator {
+	return &Evaluator{Env: object.NewEnvironment()}
+}
+
+// Reset replaces the environment with an empty one
+func (e *Evaluator) Reset() {
+	e.Env = object.NewEnvironment()
+}
+
+// Run evaluates program
+func (e *Evaluator) Run(program *ast.Program) (object.Object, error) {
+	result := evaluator.Eval(program, e.Env)
+
+	// the evaluator stops at the first error, wherever it occurs
+	if _, ok := result.(*object.Error); ok {
+		return result, nil
+	}
+
+	if !endsWithExpression(program) {
+		return nil, nil
+	}
+	return result, nil
+}
+
+// Define binds name to value in the environment
+func (e *Evaluator) Define(name string, value object.Object) {
+	e.Env.Set(name, value)
+}
+
+// Globals returns the bindings in the environment
+func (e *Evaluator) Globals() []Binding {
+	bindings := []Binding{}
+	for _, name := range e.Env.Names() {
+		value, _ := e.Env.Get(name)
+		bindings = append(bindings, Binding{name, value})
+	}
+	return bindings
+}
+
+// Differential runs each program on both the VM and the Evaluator, and
+// reports an error if their results or output differ. The output of each
+// engine is held until both have finished, and only the VM's is written.
+type Differential struct {
+	VM        *VM
+	Evaluator *Evaluator
+}
+
+// NewDifferential returns a Differential engine
+func NewDifferential() *Differential {
+	return &Differential{VM: NewVM(), Evaluator: NewEvaluator()}
+}
+
+// Run executes program on both engines. It returns the VM's result if the
+// engines agree, and a *MismatchError otherwise.
+func (e *Differential) Run(program *ast.Program) (object.Object, error) {
+	out := object.Output
+	defer func() { object.Output = out }()
+
+	vmOutput := &bytes.Buffer{}
+	object.Output = vmOutput
+	vmResult, vmErr := e.VM.Run(program)
+
+	evalOutput := &bytes.Buffer{}
+	object.Output = evalOutput
+	evalResult, evalErr := e.Evaluator.Run(program)
+
+	out.Write(vmOutput.Bytes())
+
+	if vmOutput.String() != evalOutput.String() ||
+		!sameOutcome(vmResult, vmErr, evalResult, evalErr) {
+		return nil, &MismatchError{
+			VMResult:   vmResult,
+			VMErr:      vmErr,
+			VMOutput:   vmOutput.String(),
+			EvalResult: evalResult,
+			EvalErr:    evalErr,
+			EvalOutput: evalOutput.String(),
+		}
+	}
+
+	return vmResult, vmErr
+}
+
+// Define binds name to value on both engines
+func (e *Differential) Define(name string, value object.Object) {
+	e.VM.Define(name, value)
+	e.Evaluator.Define(name, value)
+}
+
+// Globals returns the VM's globals
+func (e *Differential) Globals() []Binding {
+	return e.VM.Globals()
+}
+
+// Reset resets both engines
+func (e *Differential) Reset() {
+	e.VM.Reset()
+	e.Evaluator.Reset()
+}
+
+// MismatchError is returned by Differential when the engines disagree
+type MismatchError struct {
+	VMResult   object.Object
+	VMErr      error
+	VMOutput   string
+	EvalResult object.Object
+	EvalErr    error
+	EvalOutput string
+}
+
+func (e *MismatchError) Error() string {
+	if e.VMOutput != e.EvalOutput {
+		return fmt.Sprintf("engines disagree: vm printed %q, eval printed %q",
+			e.VMOutput, e.EvalOutput)
+	}
+	return fmt.Sprintf("engines disagree: vm returned %s, eval returned %s",
+		describe(e.VMResult, e.VMErr), describe(e.EvalResult, e.EvalErr))
+}
+
+func describe(result object.Object, err error) string {
+	switch {
+	case err != nil:
+		return "error: " + err.Error()
+	case result == nil:
+		return "no value"
+	default:
+		return result.Inspect()
+	}
+}
+
+// sameOutcome reports whether two engines agree. Error messages are not
+// compared, as the engines word them differently; failing in both counts
+// as agreement.
+func sameOutcome(a object.Object, aErr error, b object.Object, bErr error) bool {
+	aFailed := aErr != nil || isError(a)
+	bFailed := bErr != nil || isError(b)
+	if aFailed || bFailed {
+		return aFailed == bFailed
+	}
+
+	return equal(a, b)
+}
+
+// equal compares objects by value. Functions are opaque, so any two are equal.
+func equal(a, b object.Object) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	if isFunction(a) || isFunction(b) {
+		return isFunction(a) && isFunction(b)
+	}
+	if a.Type() != b.Type() {
+		return false
+	}
+
+	switch a := a.(type) {
+	case *object.Array:
+		b := b.(*object.Array)
+		if len(a.Elements) != len(b.Elements) {
+			return false
+		}
+		for i := range a.Elements {
+			if !equal(a.Elements[i], b.Elements[i]) {
+				return false
+			}
+		}
+		return true
+	case *object.Hash:
+		b := b.(*object.Hash)
+		if len(a.Pairs) != len(b.Pairs) {
+			return false
+		}
+		for key, pair := range a.Pairs {
+			other, ok := b.Pairs[key]
+			if !ok || !equal(pair.Value, other.Value) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a.Inspect() == b.Inspect()
+	}
+}
+
+func isFunction(obj object.Object) bool {
+	switch obj.(type) {
+	case *object.Function, *object.Closure, *object.CompiledFunction, *object.Builtin:
+		return true
+	default:
+		return false
+	}
+}
+
+func isError(obj object.Object) bool {
+	_, ok := obj.(*object.Error)
+	return ok
+}
+
+func sortBindings(bindings []Binding) {
+	sort.Slice(bindings, func(i, j int) bool {
+		return bindings[i].Name < bindings[j].Name
+	})
+}
+
+func endsWithExpression(program *ast.Program) bool {
+	n := len(program.Statements)
+	if n == 0 {
+		return false
+	}
+	_, ok := program.Statements[n-1].(*ast.ExpressionStatement)
+	return ok
+}
+
+// endsWithPop reports whether the last instruction is OpPop, which the
+// compiler emits after a program's final expression statement
+func endsWithPop(ins code.Instructions) bool {
+	last := -1
+	for ip := 0; ip < len(ins); {
+		def, err := code.Lookup(ins[ip])
+		if err != nil {
+			return false
+		}
+		_, read := code.ReadOperands(def, ins[ip+1:])
+		last = ip
+		ip += 1 + read
+	}
+	return last >= 0 && code.Opcode(ins[last]) == code.OpPop
+}
@@ -0,0 +1,252 @@
+package engine
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/mikeraimondi/monkey/ast"
+	"github.com/mikeraimondi/monkey/code"
+	"github.com/mikeraimondi/monkey/compiler"
+	"github.com/mikeraimondi/monkey/lexer"
+	"github.com/mikeraimondi/monkey/object"
+	"github.com/mikeraimondi/monkey/parser"
+	"github.com/mikeraimondi/monkey/vm"
+)
+
+func parse(t testing.TB, input string) *ast.Program {
+	t.Helper()
+
+	l := lexer.New(input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	if errors := p.Errors(); len(errors) != 0 {
+		t.Fatalf("parser errors for %q: %s", input, strings.Join(errors, "; "))
+	}
+	return program
+}
+
+func TestEngines(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`1 + 2`, "3"},
+		{`let a = 1;`, ""},
+		{`let a = 5; let b = fn(x) { x * a }; b(2);`, "10"},
+		{`[1, 2 * 2, "three"]`, "[1, 4, three]"},
+		{`if (false) { 1 }`, "null"},
+		{`len(1)`, "ERROR: argument to `len` not supported. got INTEGER"},
+		{`len(greeting)`, "5"},
+	}
+
+	for _, name := range Names {
+		for _, tt := range tests {
+			t.Run(name+" "+tt.input, func(t *testing.T) {
+				eng, err := New(name)
+				if err != nil {
+					t.Fatalf("New failed: %s", err)
+				}
+				eng.Define("greeting", &object.String{Value: "hello"})
+
+				result, err := eng.Run(parse(t, tt.input))
+				if err != nil {
+					t.Fatalf("Run failed: %s", err)
+				}
+
+				actual := ""
+				if result != nil {
+					actual = result.Inspect()
+				}
+				if actual != tt.expected {
+					t.Errorf("wrong result. expected %q, got %q", tt.expected, actual)
+				}
+			})
+		}
+	}
+}
+
+func TestEngineKeepsState(t *testing.T) {
+	for _, name := range Names {
+		t.Run(name, func(t *testing.T) {
+			eng, err := New(name)
+			if err != nil {
+				t.Fatalf("New failed: %s", err)
+			}
+
+			_, err = eng.Run(parse(t, `let double = fn(x) { x * 2 };`))
+			if err != nil {
+				t.Fatalf("Run failed: %s", err)
+			}
+			result, err := eng.Run(parse(t, `double(21)`))
+			if err != nil {
+				t.Fatalf("Run failed: %s", err)
+			}
+			if actual := result.Inspect(); actual != "42" {
+				t.Errorf("wrong result. expected 42, got %s", actual)
+			}
+		})
+	}
+}
+
+func TestUnknownEngine(t *testing.T) {
+	_, err := New("jit")
+	if err == nil {
+		t.Fatalf("expected error, got none")
+	}
+	expected := `unknown engine "jit", want one of vm, eval, diff`
+	if err.Error() != expected {
+		t.Errorf("wrong error. expected %q, got %q", expected, err)
+	}
+}
+
+func TestVMCompilationError(t *testing.T) {
+	_, err := NewVM().Run(parse(t, `x`))
+	if err == nil {
+		t.Fatalf("expected error, got none")
+	}
+	e, ok := err.(*Error)
+	if !ok {
+		t.Fatalf("error is not *Error. got %T", err)
+	}
+	if e.Phase != Compilation {
+		t.Errorf("wrong phase. expected %s, got %s", Compilation, e.Phase)
+	}
+}
+
+func TestVMOptions(t *testing.T) {
+	eng := NewVM()
+	eng.Options = []vm.Option{vm.WithMaxFrames(10)}
+
+	_, err := eng.Run(parse(t, `let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(20)`))
+	if err == nil {
+		t.Fatalf("expected error, got none")
+	}
+	expected := "execution failure: 1:47: maximum recursion depth exceeded"
+	if err.Error() != expected {
+		t.Errorf("wrong error. expected %q, got %q", expected, err)
+	}
+}
+
+func TestVMCompilerOptions(t *testing.T) {
+	eng := NewVM()
+	eng.CompilerOptions = []compiler.Option{compiler.WithOptimization()}
+
+	inputs := []struct {
+		input    string
+		expected string
+	}{
+		{"let x = 5; 1; 2", "2"},
+		{"if (false) { 1 }; x", "5"},
+		{"let f = fn() { return x; 3 }; f()", "5"},
+	}
+
+	for _, tt := range inputs {
+		result, err := eng.Run(parse(t, tt.input))
+		if err != nil {
+			t.Fatalf("%s: %s", tt.input, err)
+		}
+		if result == nil || result.Inspect() != tt.expected {
+			t.Errorf("%s: wrong result. expected %s, got %v", tt.input, tt.expected, result)
+		}
+	}
+}
+
+func TestVMConstantsDoNotGrow(t *testing.T) {
+	eng := NewVM()
+	for i := 0; i < 100; i++ {
+		_, err := eng.Run(parse(t, `let x = 1 + 2; "monkey"`))
+		if err != nil {
+			t.Fatalf("run error: %s", err)
+		}
+	}
+
+	if len(eng.Constants) != 3 {
+		t.Errorf("wrong number of constants. expected 3, got %d", len(eng.Constants))
+	}
+}
+
+func TestRunBytecodeVerifies(t *testing.T) {
+	bytecode := &compiler.Bytecode{Instructions: code.Make(code.OpAdd)}
+
+	_, err := NewVM().RunBytecode(bytecode)
+	if err == nil {
+		t.Fatalf("expected error, got none")
+	}
+	expected := "verification failure: stack underflow at 0"
+	if err.Error() != expected {
+		t.Errorf("wrong error. expected %q, got %q", expected, err)
+	}
+}
+
+func TestDifferentialMismatch(t *testing.T) {
+	// the evaluator stops at the first error, the VM carries on
+	_, err := NewDifferential().Run(parse(t, `let x = len(1); 5`))
+	if err == nil {
+		t.Fatalf("expected mismatch, got none")
+	}
+	if _, ok := err.(*MismatchError); !ok {
+		t.Fatalf("error is not *MismatchError. got %T (%s)", err, err)
+	}
+
+	expected := "engines disagree: vm returned 5, " +
+		"eval returned ERROR: argument to `len` not supported. got INTEGER"
+	if err.Error() != expected {
+		t.Errorf("wrong error.\nexpected %q\ngot %q", expected, err)
+	}
+}
+
+func TestDifferentialOutput(t *testing.T) {
+	out := object.Output
+	defer func() { object.Output = out }()
+
+	tests := []struct {
+		input          string
+		expectedOutput string
+		expectedErr    string
+	}{
+		{`puts(1); puts("a"); 2`, "1\na\n", ""},
+		{
+			// the evaluator stops at the first error, the VM carries on
+			`let x = len(1); puts(5)`,
+			"5\n",
+			`engines disagree: vm printed "5\n", eval printed ""`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			object.Output = buf
+
+			_, err := NewDifferential().Run(parse(t, tt.input))
+			if tt.expectedErr == "" && err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if tt.expectedErr != "" && (err == nil || err.Error() != tt.expectedErr) {
+				t.Errorf("wrong error. expected %q, got %v", tt.expectedErr, err)
+			}
+			if buf.String() != tt.expectedOutput {
+				t.Errorf("wrong output. expected %q, got %q", tt.expectedOutput, buf.String())
+			}
+		})
+	}
+}
+
+func TestDifferentialAgreement(t *testing.T) {
+	tests := []string{
+		`{"a": 1, "b": [true, "c"], 3: fn() {}}`,
+		`fn(x) { x }`,
+		`5 + true`,
+		`let f = fn(x) { if (x == 0) { 1 } else { f(x - 1) } }; let g = f; f = fn(x) { 100 + x }; g(2)`,
+	}
+
+	for _, input := range tests {
+		t.Run(input, func(t *testing.T) {
+			_, err := NewDifferential().Run(parse(t, input))
+			if _, ok := err.(*MismatchError); ok {
+				t.Errorf("unexpected mismatch: %s", err)
+			}
+		})
+	}
+}
@@ -11,4 +11,6 @@ var builtins = map[string]*object.Builtin{
 	"rest":  object.GetBuiltinByName("rest"),
 	"push":  object.GetBuiltinByName("push"),
 	"puts":  object.GetBuiltinByName("puts"),
+	"int":   object.GetBuiltinByName("int"),
+	"float": object.GetBuiltinByName("float"),
 }
@@ -2,15 +2,19 @@ package evaluator
 
 import (
 	"fmt"
+	"math"
 
 	"github.com/mikeraimondi/monkey/ast"
 	"github.com/mikeraimondi/monkey/object"
+	"github.com/mikeraimondi/monkey/token"
 )
 
 var (
-	NULL  = &object.Null{}
-	TRUE  = &object.Boolean{Value: true}
-	FALSE = &object.Boolean{Value: false}
+	NULL     = &object.Null{}
+	TRUE     = &object.Boolean{Value: true}
+	FALSE    = &object.Boolean{Value: false}
+	BREAK    = &object.Break{}
+	CONTINUE = &object.Continue{}
 )
 
 func Eval(node ast.Node, env *object.Environment) object.Object {
@@ -34,6 +38,14 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 			return val
 		}
 		env.Set(node.Name.Value, val)
+	case *ast.WhileStatement:
+		return evalWhileStatement(node, env)
+	case *ast.ForStatement:
+		return evalForStatement(node, env)
+	case *ast.BreakStatement:
+		return BREAK
+	case *ast.ContinueStatement:
+		return CONTINUE
 	case *ast.Identifier:
 		return evalIdentifier(node, env)
 
@@ -51,11 +63,14 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		if isError(left) {
 			return left
 		}
+		if node.Operator == "&&" || node.Operator == "||" {
+			return evalLogicalExpression(node, left, env)
+		}
 		right := Eval(node.Right, env)
 		if isError(right) {
 			return right
 		}
-		return evalInfixExpression(node.Operator, left, right, env)
+		return evalInfixExpression(node, left, right, env)
 	case *ast.CallExpression:
 		if node.Function.TokenLiteral() == "quote" {
 			return quote(node.Arguments[0], env)
@@ -89,12 +104,16 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 			return index
 		}
 		return evalIndexExpression(left, index)
+	case *ast.AssignExpression:
+		return evalAssignExpression(node, env)
 	case *ast.HashLiteral:
 		return evalHashLiteral(node, env)
 	case *ast.StringLiteral:
 		return &object.String{Value: node.Value}
 	case *ast.IntegerLiteral:
 		return &object.Integer{Value: node.Value}
+	case *ast.FloatLiteral:
+		return &object.Float{Value: node.Value}
 	case *ast.Boolean:
 		return nativeBoolToBooleanObject(node.Value)
 	}
@@ -113,6 +132,8 @@ func evalProgram(program *ast.Program, env *object.Environment) object.Object {
 			return result.Value
 		case *object.Error:
 			return result
+		case *object.Break, *object.Continue:
+			return newError("%s outside loop", result.Inspect())
 		}
 	}
 
@@ -126,16 +147,73 @@ func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) obje
 		result = Eval(statement, env)
 
 		if result != nil {
-			rt := result.Type()
-			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ {
+			switch result.Type() {
+			case object.RETURN_VALUE_OBJ, object.ERROR_OBJ,
+				object.BREAK_OBJ, object.CONTINUE_OBJ:
 				return result
 			}
 		}
 	}
 
+	// a block that ends in a statement has no value of its own
+	if result == nil {
+		return NULL
+	}
+
 	return result
 }
 
+func evalWhileStatement(ws *ast.WhileStatement, env *object.Environment) object.Object {
+	for {
+		// a break or continue in the condition belongs to this loop
+		condition := Eval(ws.Condition, env)
+		switch condition.(type) {
+		case *object.Break:
+			return nil
+		case *object.Continue:
+			continue
+		}
+		if isError(condition) {
+			return condition
+		}
+		if !isTruthy(condition) {
+			return nil
+		}
+
+		switch result := Eval(ws.Body, env); result.(type) {
+		case *object.ReturnValue, *object.Error:
+			return result
+		case *object.Break:
+			return nil
+		}
+	}
+}
+
+func evalForStatement(fs *ast.ForStatement, env *object.Environment) object.Object {
+	iterable := Eval(fs.Iterable, env)
+	if isError(iterable) {
+		return iterable
+	}
+
+	array, ok := iterable.(*object.Array)
+	if !ok {
+		return newError("cannot iterate over %s", iterable.Type())
+	}
+
+	for _, element := range array.Elements {
+		env.Set(fs.Name.Value, element)
+
+		switch result := Eval(fs.Body, env); result.(type) {
+		case *object.ReturnValue, *object.Error:
+			return result
+		case *object.Break:
+			return nil
+		}
+	}
+
+	return nil
+}
+
 func evalExpressions(
 	exps []ast.Expression,
 	env *object.Environment,
@@ -180,7 +258,7 @@ func evalIdentifier(
 		return builtin
 	}
 
-	return newError("identifier not found: " + node.Value)
+	return newError("identifier not found: %s", node.Value)
 }
 
 func isTruthy(obj object.Object) bool {
@@ -208,13 +286,16 @@ func evalPrefixExpression(operator string, right object.Object) object.Object {
 }
 
 func evalInfixExpression(
-	operator string,
+	node *ast.InfixExpression,
 	left, right object.Object,
 	env *object.Environment,
 ) object.Object {
+	operator := node.Operator
 	switch {
 	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
-		return evalIntegerInfixExpression(operator, left, right)
+		return evalIntegerInfixExpression(operator, node.Pos(), left, right)
+	case isNumber(left) && isNumber(right):
+		return evalFloatInfixExpression(operator, left, right)
 	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
 		return evalStringInfixExpression(operator, left, right)
 	case operator == "==":
@@ -230,8 +311,24 @@ func evalInfixExpression(
 	}
 }
 
+// evalLogicalExpression evaluates the right operand only if the left one does
+// not decide the result. The result is the last operand evaluated.
+func evalLogicalExpression(
+	node *ast.InfixExpression,
+	left object.Object,
+	env *object.Environment,
+) object.Object {
+	if isTruthy(left) == (node.Operator == "||") {
+		return left
+	}
+	return Eval(node.Right, env)
+}
+
+// evalIntegerInfixExpression evaluates an operator on integers. Division
+// and modulo by zero are reported at pos, the operator's position.
 func evalIntegerInfixExpression(
 	operator string,
+	pos token.Position,
 	left, right object.Object,
 ) object.Object {
 	leftVal := left.(*object.Integer).Value
@@ -245,11 +342,23 @@ func evalIntegerInfixExpression(
 	case "*":
 		return &object.Integer{Value: leftVal * rightVal}
 	case "/":
+		if rightVal == 0 {
+			return newError("%s: division by zero", pos)
+		}
 		return &object.Integer{Value: leftVal / rightVal}
+	case "%":
+		if rightVal == 0 {
+			return newError("%s: modulo by zero", pos)
+		}
+		return &object.Integer{Value: leftVal % rightVal}
 	case "<":
 		return nativeBoolToBooleanObject(leftVal < rightVal)
 	case ">":
 		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
 	case "==":
 		return nativeBoolToBooleanObject(leftVal == rightVal)
 	case "!=":
@@ -260,6 +369,55 @@ func evalIntegerInfixExpression(
 	}
 }
 
+// evalFloatInfixExpression evaluates an operation on two numbers, at least one
+// of which is a float
+func evalFloatInfixExpression(
+	operator string,
+	left, right object.Object,
+) object.Object {
+	leftVal := toFloat(left)
+	rightVal := toFloat(right)
+
+	switch operator {
+	case "+":
+		return &object.Float{Value: leftVal + rightVal}
+	case "-":
+		return &object.Float{Value: leftVal - rightVal}
+	case "*":
+		return &object.Float{Value: leftVal * rightVal}
+	case "/":
+		return &object.Float{Value: leftVal / rightVal}
+	case "%":
+		return &object.Float{Value: math.Mod(leftVal, rightVal)}
+	case "<":
+		return nativeBoolToBooleanObject(leftVal < rightVal)
+	case ">":
+		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal)
+	default:
+		return newError("unknown operator: %s %s %s",
+			left.Type(), operator, right.Type())
+	}
+}
+
+func isNumber(obj object.Object) bool {
+	return obj.Type() == object.INTEGER_OBJ || obj.Type() == object.FLOAT_OBJ
+}
+
+func toFloat(obj object.Object) float64 {
+	if i, ok := obj.(*object.Integer); ok {
+		return float64(i.Value)
+	}
+	return obj.(*object.Float).Value
+}
+
 func evalStringInfixExpression(
 	operator string,
 	left, right object.Object,
@@ -319,6 +477,67 @@ func evalHashIndexExpression(hash, index object.Object) object.Object {
 	return pair.Value
 }
 
+func evalAssignExpression(
+	node *ast.AssignExpression,
+	env *object.Environment,
+) object.Object {
+	switch target := node.Target.(type) {
+	case *ast.Identifier:
+		value := Eval(node.Value, env)
+		if isError(value) {
+			return value
+		}
+		if !env.Assign(target.Value, value) {
+			if _, ok := builtins[target.Value]; ok {
+				return newError("cannot assign to builtin %s", target.Value)
+			}
+			return newError("identifier not found: %s", target.Value)
+		}
+		return value
+	case *ast.IndexExpression:
+		left := Eval(target.Left, env)
+		if isError(left) {
+			return left
+		}
+		index := Eval(target.Index, env)
+		if isError(index) {
+			return index
+		}
+		value := Eval(node.Value, env)
+		if isError(value) {
+			return value
+		}
+		return evalIndexAssignment(left, index, value)
+	default:
+		return newError("cannot assign to %s", node.Target.String())
+	}
+}
+
+func evalIndexAssignment(left, index, value object.Object) object.Object {
+	switch left := left.(type) {
+	case *object.Array:
+		i, ok := index.(*object.Integer)
+		if !ok {
+			return newError("array index must be INTEGER. got %s", index.Type())
+		}
+		if i.Value < 0 || i.Value >= int64(len(left.Elements)) {
+			return newError("index %d out of range for array of length %d",
+				i.Value, len(left.Elements))
+		}
+		left.Elements[i.Value] = value
+	case *object.Hash:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return newError("unusable as hash key: %s", index.Type())
+		}
+		left.Pairs[key.HashKey()] = object.HashPair{Key: index, Value: value}
+	default:
+		return newError("index assignment not supported: %s", left.Type())
+	}
+
+	return value
+}
+
 func evalHashLiteral(
 	node *ast.HashLiteral,
 	env *object.Environment,
@@ -369,11 +588,14 @@ func evalBangOperatorExpression(right object.Object) object.Object {
 }
 
 func evalMinusPrefixOperatorExpression(right object.Object) object.Object {
-	if right.Type() != object.INTEGER_OBJ {
+	switch right := right.(type) {
+	case *object.Integer:
+		return &object.Integer{Value: -right.Value}
+	case *object.Float:
+		return &object.Float{Value: -right.Value}
+	default:
 		return newError("unknown operator: -%s", right.Type())
 	}
-	value := right.(*object.Integer).Value
-	return &object.Integer{Value: -value}
 }
 
 func applyFunction(fn object.Object, args []object.Object) object.Object {
@@ -381,6 +603,10 @@ func applyFunction(fn object.Object, args []object.Object) object.Object {
 	case *object.Function:
 		extendedEnv := extendFunctionEnv(fn, args)
 		evaluated := Eval(fn.Body, extendedEnv)
+		switch evaluated.(type) {
+		case *object.Break, *object.Continue:
+			return newError("%s outside loop", evaluated.Inspect())
+		}
 		return unwrapReturnValue(evaluated)
 	case *object.Builtin:
 		if result := fn.Fn(args...); result != nil {
@@ -417,9 +643,15 @@ func newError(format string, a ...interface{}) *object.Error {
 	return &object.Error{Message: fmt.Sprintf(format, a...)}
 }
 
+// isError reports whether obj ends the evaluation of the expression it is
+// part of. Besides errors, that is a break or continue on its way to the
+// enclosing loop.
 func isError(obj object.Object) bool {
 	if obj != nil {
-		return obj.Type() == object.ERROR_OBJ
+		switch obj.Type() {
+		case object.ERROR_OBJ, object.BREAK_OBJ, object.CONTINUE_OBJ:
+			return true
+		}
 	}
 
 	return false
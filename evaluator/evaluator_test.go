@@ -1,6 +1,7 @@
 package evaluator
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/mikeraimondi/monkey/lexer"
@@ -28,16 +29,42 @@ func TestEvalIntegerExpression(t *testing.T) {
 		{"3 * 3 * 3 + 10", 37},
 		{"3 * (3 * 3) + 10", 37},
 		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
+		{"7 % 3", 1},
+		{"-7 % 3", -1},
+		{"2 + 10 % 4 * 3", 8},
 	}
 
 	for _, tt := range tests {
 		t.Run("eval integer expression "+tt.input, func(t *testing.T) {
-			evaluated := testEval(tt.input)
+			evaluated := testEval(t, tt.input)
 			testIntegerObject(t, evaluated, tt.expected)
 		})
 	}
 }
 
+func TestEvalFloatExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"1.5", 1.5},
+		{"-2.5", -2.5},
+		{"1.5 + 1.5", 3},
+		{"1 + 0.5", 1.5},
+		{"0.5 * 4", 2},
+		{"7 / 2.0", 3.5},
+		{"7.5 % 2", 1.5},
+		{"2.5e2 - 50", 200},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			evaluated := testEval(t, tt.input)
+			testFloatObject(t, evaluated, tt.expected)
+		})
+	}
+}
+
 func TestEvalBooleanExpression(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -53,6 +80,19 @@ func TestEvalBooleanExpression(t *testing.T) {
 		{"1 != 1", false},
 		{"1 == 2", false},
 		{"1 != 2", true},
+		{"1.5 < 2", true},
+		{"2 > 1.5", true},
+		{"1 == 1.0", true},
+		{"0.1 + 0.2 != 0.3", true},
+		{"1 <= 1", true},
+		{"2 <= 1", false},
+		{"1 >= 2", false},
+		{"2.5 >= 2", true},
+		{"true && true", true},
+		{"true && false", false},
+		{"false || true", true},
+		{"false || false", false},
+		{"1 < 2 && 2 < 3", true},
 		{"true == true", true},
 		{"false == false", true},
 		{"true == false", false},
@@ -70,12 +110,125 @@ func TestEvalBooleanExpression(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run("eval boolean expression "+tt.input, func(t *testing.T) {
-			evaluated := testEval(tt.input)
+			evaluated := testEval(t, tt.input)
 			testBooleanObject(t, evaluated, tt.expected)
 		})
 	}
 }
 
+func TestLogicalOperators(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"1 && 2", 2},
+		{"false && 2", false},
+		{"1 || 2", 1},
+		{"if (false) { 1 } || 2", 2},
+		{"false && undefined", false},
+		{"true || undefined", true},
+		{"let x = 1; (x > 0 && x) || 10", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			evaluated := testEval(t, tt.input)
+			switch expected := tt.expected.(type) {
+			case int:
+				testIntegerObject(t, evaluated, int64(expected))
+			case bool:
+				testBooleanObject(t, evaluated, expected)
+			}
+		})
+	}
+}
+
+func TestLoops(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"while (false) { 1 }; 5", 5},
+		{"while (true) { break; }; 1", 1},
+		{"for (x in [1, 2, 3]) { }; x", 3},
+		{"for (x in [1, 2, 3]) { if (x == 2) { break; } }; x", 2},
+		{"let f = fn(a) { for (x in a) { if (x > 1) { return x } } }; f([1, 2, 3])", 2},
+		{"let f = fn(a) { for (x in a) { if (x < 3) { continue; } return x; } }; f([1, 2, 3, 4])", 3},
+		{"for (x in 1) { }", "cannot iterate over INTEGER"},
+		{"break;", "break outside loop"},
+		{"while (true) { fn() { continue }() }", "continue outside loop"},
+		{"let n = 0; for (x in [1, 2, 3]) { for (y in [4, 5]) { [1, if (y == 5) { break; } else { y }] } n = n + x; }; n", 6},
+		{"let r = 0; while (true) { r = [1, if (true) { break; }, 3]; }; r", 0},
+		{"let s = 0; for (x in [1, 2, 3]) { s = s + x; len(if (true) { continue; }) }; s", 6},
+		{"let i = 0; while (i < 1000) { i = i + 1; len([i, if (true) { continue; }]) }; i", 1000},
+		{`for (x in [1, 2]) { {"a": x, "b": if (x == 1) { continue; } else { x }} }; x`, 2},
+		{"for (x in [1, 2]) { 1 + if (true) { break; } else { 0 } }; x", 1},
+		{"let a = [0]; for (x in [1, 2]) { a[0] = if (x == 2) { break; } else { x } }; a[0]", 1},
+		{"let i = 0; while (if (i == 3) { break; } else { true }) { i = i + 1 }; i", 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			evaluated := testEval(t, tt.input)
+			switch expected := tt.expected.(type) {
+			case int:
+				testIntegerObject(t, evaluated, int64(expected))
+			case string:
+				errObj, ok := evaluated.(*object.Error)
+				if !ok {
+					t.Fatalf("object is not Error. got %T (%+v)", evaluated, evaluated)
+				}
+				if errObj.Message != expected {
+					t.Errorf("wrong error message. expected %q. got %q",
+						expected, errObj.Message)
+				}
+			}
+		})
+	}
+}
+
+func TestAssignment(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let x = 1; x = 2", 2},
+		{"let x = 1; x = 2; x", 2},
+		{"let x = 1; let y = 2; x = y = 3; x + y", 6},
+		{"let i = 0; while (i < 5) { i = i + 1 }; i", 5},
+		{"let s = 0; for (x in [1, 2, 3]) { s = s + x }; s", 6},
+		{"let f = fn(x) { x = x * 2; x }; f(3)", 6},
+		{"let a = [1, 2, 3]; a[1] = 5; a[0] + a[1] + a[2]", 9},
+		{"let a = [1, 2]; let b = a; b[0] = 7; a[0]", 7},
+		{`let h = {}; h["k"] = 1; h["k"] = h["k"] + 1; h["k"]`, 2},
+		{"let f = fn() { let n = 0; fn() { n = n + 1 } }; let c = f(); c(); c(); c()", 3},
+		{"let a = []; let i = 0; while (i < 5000) { a = push(a, i); i = i + 1 }; len(a)", 5000},
+		{"let a = [1]; a[5] = 2", "index 5 out of range for array of length 1"},
+		{"let s = \"x\"; s[0] = 1", "index assignment not supported: STRING"},
+		{"y = 1", "identifier not found: y"},
+		{"len = 1", "cannot assign to builtin len"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			evaluated := testEval(t, tt.input)
+			switch expected := tt.expected.(type) {
+			case int:
+				testIntegerObject(t, evaluated, int64(expected))
+			case string:
+				errObj, ok := evaluated.(*object.Error)
+				if !ok {
+					t.Fatalf("object is not Error. got %T (%+v)", evaluated, evaluated)
+				}
+				if errObj.Message != expected {
+					t.Errorf("wrong error message. expected %q. got %q",
+						expected, errObj.Message)
+				}
+			}
+		})
+	}
+}
+
 func TestIfElseExpression(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -91,7 +244,7 @@ func TestIfElseExpression(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run("if/else expression "+tt.input, func(t *testing.T) {
-			evaluated := testEval(tt.input)
+			evaluated := testEval(t, tt.input)
 			integer, ok := tt.expected.(int)
 			if ok {
 				testIntegerObject(t, evaluated, int64(integer))
@@ -121,7 +274,7 @@ func TestReturnStatements(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run("return statement "+tt.input, func(t *testing.T) {
-			evaluated := testEval(tt.input)
+			evaluated := testEval(t, tt.input)
 			testIntegerObject(t, evaluated, tt.expected)
 		})
 	}
@@ -140,7 +293,7 @@ func TestBangOperator(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run("eval bang operator "+tt.input, func(t *testing.T) {
-			evaluated := testEval(tt.input)
+			evaluated := testEval(t, tt.input)
 			testBooleanObject(t, evaluated, tt.expected)
 		})
 	}
@@ -197,10 +350,22 @@ if (10 > 1) {
 			`{"name": "Monkey"}[fn(x) { x }];`,
 			"unusable as hash key: FUNCTION",
 		},
+		{
+			"1 / 0",
+			"1:3: division by zero",
+		},
+		{
+			"let f = fn(a) { 10 % a }; f(0); 5",
+			"1:20: modulo by zero",
+		},
+		{
+			"let x = 0;\n1 + 2 / x",
+			"2:7: division by zero",
+		},
 	}
 	for _, tt := range tests {
 		t.Run("error handling: "+tt.input, func(t *testing.T) {
-			evaluated := testEval(tt.input)
+			evaluated := testEval(t, tt.input)
 
 			errObj, ok := evaluated.(*object.Error)
 			if !ok {
@@ -229,7 +394,7 @@ func TestIfStatements(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run("eval 'if' statement "+tt.input, func(t *testing.T) {
-			testIntegerObject(t, testEval(tt.input), tt.expected)
+			testIntegerObject(t, testEval(t, tt.input), tt.expected)
 		})
 	}
 }
@@ -237,7 +402,7 @@ func TestIfStatements(t *testing.T) {
 func TestFunctionObject(t *testing.T) {
 	input := "fn(x) { x + 2; };"
 
-	evaluated := testEval(input)
+	evaluated := testEval(t, input)
 	fn, ok := evaluated.(*object.Function)
 	if !ok {
 		t.Fatalf("object is not Function. got %T (%+v)", evaluated, evaluated)
@@ -272,7 +437,7 @@ func TestFunctionApplication(t *testing.T) {
 		{"fn(x) { x; }(5)", 5},
 	}
 	for _, tt := range tests {
-		testIntegerObject(t, testEval(tt.input), tt.expected)
+		testIntegerObject(t, testEval(t, tt.input), tt.expected)
 	}
 }
 
@@ -283,13 +448,33 @@ func TestClosures(t *testing.T) {
 };
    let addTwo = newAdder(2);
    addTwo(2);`
-	testIntegerObject(t, testEval(input), 4)
+	testIntegerObject(t, testEval(t, input), 4)
+}
+
+func TestSharedCapturedVariables(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let f = fn() { let n = 0; let inc = fn() { n = n + 1 }; inc(); inc(); n }; f()", 2},
+		{"let make = fn() { let n = 0; [fn() { n = n + 1 }, fn() { n }] }; let p = make(); p[0](); p[0](); p[1]()", 2},
+		{"let make = fn() { let n = 0; fn() { n = n + 1 } }; let a = make(); let b = make(); a(); a(); b()", 1},
+		{"let f = fn() { let n = 0; let g = fn() { fn() { n = n + 10 } }; g()(); n }; f()", 10},
+		{"let f = fn() { let n = 1; let g = fn() { n }; n = 2; g() }; f()", 2},
+		{"let f = fn() { let fact = fn(n) { if (n == 0) { 1 } else { n * fact(n - 1) } }; fact(5) }; f()", 120},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			testIntegerObject(t, testEval(t, tt.input), tt.expected)
+		})
+	}
 }
 
 func TestStringLiteral(t *testing.T) {
 	input := `"Hello World!"`
 
-	evaluated := testEval(input)
+	evaluated := testEval(t, input)
 	str, ok := evaluated.(*object.String)
 	if !ok {
 		t.Fatalf("object is not String. got %T (%+v)", evaluated, evaluated)
@@ -303,7 +488,7 @@ func TestStringLiteral(t *testing.T) {
 func TestStringConcatenation(t *testing.T) {
 	input := `"Hello" + " " + "World!"`
 
-	evaluated := testEval(input)
+	evaluated := testEval(t, input)
 	str, ok := evaluated.(*object.String)
 	if !ok {
 		t.Fatalf("object is not a string. got %T (%+v)", evaluated, evaluated)
@@ -337,15 +522,25 @@ func TestBuiltinFunctions(t *testing.T) {
 		{`rest([])`, nil},
 		{`push([], 1)`, []int{1}},
 		{`push(1, 1)`, "argument to `push` not supported. got INTEGER"},
+		{`int(3.9)`, 3},
+		{`int(-3.9)`, -3},
+		{`int("42")`, 42},
+		{`int("x")`, "cannot convert \"x\" to INTEGER"},
+		{`int([])`, "argument to `int` not supported. got ARRAY"},
+		{`float(2)`, 2.0},
+		{`float("0.25")`, 0.25},
+		{`float(true)`, "argument to `float` not supported. got BOOLEAN"},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.input, func(t *testing.T) {
-			evaluated := testEval(tt.input)
+			evaluated := testEval(t, tt.input)
 
 			switch expected := tt.expected.(type) {
 			case int:
 				testIntegerObject(t, evaluated, int64(expected))
+			case float64:
+				testFloatObject(t, evaluated, expected)
 			case nil:
 				testNullObject(t, evaluated)
 			case string:
@@ -380,7 +575,7 @@ func TestBuiltinFunctions(t *testing.T) {
 func TestArrayLiterals(t *testing.T) {
 	input := "[1, 2 * 2, 3 + 3]"
 
-	evaluated := testEval(input)
+	evaluated := testEval(t, input)
 	result, ok := evaluated.(*object.Array)
 	if !ok {
 		t.Fatalf("object is not Array. got %T (%+v)", evaluated, evaluated)
@@ -438,7 +633,7 @@ func TestArrayIndexExpressions(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		integer, ok := tt.expected.(int)
 		if ok {
 			testIntegerObject(t, evaluated, int64(integer))
@@ -458,7 +653,7 @@ func TestHashLiterals(t *testing.T) {
            true: 5,
            false: 6
 }`
-	evaluated := testEval(input)
+	evaluated := testEval(t, input)
 	result, ok := evaluated.(*object.Hash)
 	if !ok {
 		t.Fatalf("Eval didn't return Hash. got %T (%+v)", evaluated, evaluated)
@@ -518,10 +713,14 @@ func TestHashIndexExpressions(t *testing.T) {
 			`{false: 5}[false]`,
 			5,
 		},
+		{
+			`{1: 5}[1.0]`,
+			5,
+		},
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		integer, ok := tt.expected.(int)
 		if ok {
 			testIntegerObject(t, evaluated, int64(integer))
@@ -531,10 +730,15 @@ func TestHashIndexExpressions(t *testing.T) {
 	}
 }
 
-func testEval(input string) object.Object {
+func testEval(t *testing.T, input string) object.Object {
+	t.Helper()
+
 	l := lexer.New(input)
 	p := parser.New(l)
 	program := p.ParseProgram()
+	if errors := p.Errors(); len(errors) != 0 {
+		t.Fatalf("parser errors for %q: %s", input, strings.Join(errors, "; "))
+	}
 	env := object.NewEnvironment()
 
 	return Eval(program, env)
@@ -556,6 +760,22 @@ func testIntegerObject(t *testing.T, obj object.Object, expected int64) bool {
 	return true
 }
 
+func testFloatObject(t *testing.T, obj object.Object, expected float64) bool {
+	result, ok := obj.(*object.Float)
+	if !ok {
+		t.Errorf("object is not Float. got %T (%+v)", obj, obj)
+		return false
+	}
+
+	if result.Value != expected {
+		t.Errorf("object has wrong value. got %g. want %g",
+			result.Value, expected)
+		return false
+	}
+
+	return true
+}
+
 func testBooleanObject(t *testing.T, obj object.Object, expected bool) bool {
 	result, ok := obj.(*object.Boolean)
 	if !ok {
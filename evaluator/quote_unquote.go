@@ -29,7 +29,7 @@ func evalUnquoteCalls(quoted ast.Node, env *object.Environment) ast.Node {
 		}
 
 		unquoted := Eval(call.Arguments[0], env)
-		return convertObjectToASTNode(unquoted)
+		return convertObjectToASTNode(unquoted, call.Token)
 	})
 }
 
@@ -42,15 +42,26 @@ func isUnquoteCall(node ast.Node) bool {
 	return callExpression.Function.TokenLiteral() == "unquote"
 }
 
-func convertObjectToASTNode(obj object.Object) ast.Node {
-	// TODO In convertObjectToASTNode we create new tokens on the fly. That’s not a problem at the moment, but if our tokens would contain information about their origin, such as filename or line number, then we’d also have to update these here, which might be quite difficult for tokens that are created dynamically.
+// convertObjectToASTNode creates tokens on the fly. They take their source
+// span from origin, the unquote call that produced them.
+func convertObjectToASTNode(obj object.Object, origin token.Token) ast.Node {
 	switch obj := obj.(type) {
 	case *object.Integer:
 		t := token.Token{
 			Type:    token.INT,
 			Literal: fmt.Sprintf("%d", obj.Value),
+			Pos:     origin.Pos,
+			End:     origin.End,
 		}
 		return &ast.IntegerLiteral{Token: t, Value: obj.Value}
+	case *object.Float:
+		t := token.Token{
+			Type:    token.FLOAT,
+			Literal: obj.Inspect(),
+			Pos:     origin.Pos,
+			End:     origin.End,
+		}
+		return &ast.FloatLiteral{Token: t, Value: obj.Value}
 	case *object.Boolean:
 		var t token.Token
 		if obj.Value {
@@ -58,6 +69,7 @@ func convertObjectToASTNode(obj object.Object) ast.Node {
 		} else {
 			t = token.Token{Type: token.FALSE, Literal: "false"}
 		}
+		t.Pos, t.End = origin.Pos, origin.End
 		return &ast.Boolean{Token: t, Value: obj.Value}
 	// TODO other types
 	case *object.Quote:
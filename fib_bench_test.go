@@ -40,10 +40,23 @@ func setupBenchmark(b *testing.B) *ast.Program {
 }
 
 func BenchmarkCompiledExecutionFib(b *testing.B) {
+	benchmarkCompiledExecution(b)
+}
+
+// BenchmarkOptimizedExecutionFib runs fibonacci compiled with optimization,
+// which returns straight from its base cases and tests x against 0 and 1
+// with OpCompareConstJump
+func BenchmarkOptimizedExecutionFib(b *testing.B) {
+	benchmarkCompiledExecution(b, compiler.WithOptimization())
+}
+
+func benchmarkCompiledExecution(b *testing.B, options ...compiler.Option) {
+	b.Helper()
+
 	for i := 0; i < b.N; i++ {
 		b.StopTimer()
 		program := setupBenchmark(b)
-		comp := compiler.New()
+		comp := compiler.New(options...)
 		err := comp.Compile(program)
 		if err != nil {
 			b.Fatalf("compiler error: %s", err)
@@ -1,5 +1,9 @@
 module github.com/mikeraimondi/monkey
 
+go 1.27.1
+
+require github.com/peterh/liner v0.0.0-20180619022028-8c1271fcf47f
+
 require (
 	4d63.com/gochecknoglobals v0.0.0-20180528045811-9d4b45f35872 // indirect
 	4d63.com/gochecknoinits v0.0.0-20180528051558-14d5915061e5 // indirect
@@ -27,6 +31,7 @@ require (
 	github.com/josharian/impl v0.0.0-20180228163738-3d0f908298c4 // indirect
 	github.com/karrick/godirwalk v1.7.3 // indirect
 	github.com/kisielk/errcheck v1.1.0 // indirect
+	github.com/kisielk/gotool v1.0.0 // indirect
 	github.com/mattn/go-isatty v0.0.3 // indirect
 	github.com/mattn/go-runewidth v0.0.3 // indirect
 	github.com/mdempsky/gocode v0.0.0-20180727200127-00e7f5ac290a // indirect
@@ -36,7 +41,7 @@ require (
 	github.com/nicksnyder/go-i18n v1.10.0 // indirect
 	github.com/opennota/check v0.0.0-20180822054640-d4582481d7dc // indirect
 	github.com/pelletier/go-toml v1.2.0 // indirect
-	github.com/peterh/liner v0.0.0-20180619022028-8c1271fcf47f // indirect
+	github.com/pkg/errors v0.8.0 // indirect
 	github.com/ramya-rao-a/go-outline v0.0.0-20170803230019-9e9d089bb61a // indirect
 	github.com/rogpeppe/godef v0.0.0-20170920080713-b692db1de522 // indirect
 	github.com/sirupsen/logrus v1.0.6 // indirect
@@ -53,9 +58,12 @@ require (
 	golang.org/x/lint v0.0.0-20180702182130-06c8688daad7 // indirect
 	golang.org/x/sys v0.0.0-20180824143301-4910a1d54f87 // indirect
 	golang.org/x/text v0.3.0 // indirect
+	golang.org/x/tools v0.0.0-20180826000951-f6ba57429505 // indirect
 	gopkg.in/alecthomas/kingpin.v3-unstable v3.0.0-20171010053543-63abe20a23e2 // indirect
+	gopkg.in/check.v1 v0.0.0-20161208181325-20d25e280405 // indirect
 	gopkg.in/yaml.v2 v2.2.1 // indirect
 	honnef.co/go/tools v0.0.0-20180728063816-88497007e858 // indirect
 	mvdan.cc/interfacer v0.0.0-20180326104626-822e100dd73a // indirect
+	mvdan.cc/lint v0.0.0-20170908181259-adc824a0674b // indirect
 	mvdan.cc/unparam v0.0.0-20180827003406-8eb9bf77f9de // indirect
 )
@@ -10,14 +10,22 @@ import (
 // Lexer reads a string and tokenizes it
 type Lexer struct {
 	input        string
+	filename     string
 	position     int
 	readPosition int
 	ch           byte
+	line         int
+	column       int
 }
 
 // New returns a Lexer, ready for use
 func New(input string) *Lexer {
-	l := &Lexer{input: input}
+	return NewWithFilename("", input)
+}
+
+// NewWithFilename returns a Lexer whose token positions refer to filename
+func NewWithFilename(filename, input string) *Lexer {
+	l := &Lexer{input: input, filename: filename, line: 1}
 	l.readChar()
 	return l
 }
@@ -28,13 +36,12 @@ func (l *Lexer) NextToken() token.Token {
 
 	l.skipWhitespace()
 
+	start := l.currentPosition()
+
 	switch l.ch {
 	case '=':
 		if l.peekChar() == '=' {
-			ch := l.ch
-			l.readChar()
-			literal := string(ch) + string(l.ch)
-			tok = token.Token{Type: token.EQ, Literal: literal}
+			tok = l.readTwoCharToken(token.EQ)
 		} else {
 			tok = newToken(token.ASSIGN, l.ch)
 		}
@@ -44,10 +51,7 @@ func (l *Lexer) NextToken() token.Token {
 		tok = newToken(token.MINUS, l.ch)
 	case '!':
 		if l.peekChar() == '=' {
-			ch := l.ch
-			l.readChar()
-			literal := string(ch) + string(l.ch)
-			tok = token.Token{Type: token.NOT_EQ, Literal: literal}
+			tok = l.readTwoCharToken(token.NOT_EQ)
 		} else {
 			tok = newToken(token.BANG, l.ch)
 		}
@@ -55,10 +59,32 @@ func (l *Lexer) NextToken() token.Token {
 		tok = newToken(token.SLASH, l.ch)
 	case '*':
 		tok = newToken(token.ASTERISK, l.ch)
+	case '%':
+		tok = newToken(token.PERCENT, l.ch)
 	case '<':
-		tok = newToken(token.LT, l.ch)
+		if l.peekChar() == '=' {
+			tok = l.readTwoCharToken(token.LT_EQ)
+		} else {
+			tok = newToken(token.LT, l.ch)
+		}
 	case '>':
-		tok = newToken(token.GT, l.ch)
+		if l.peekChar() == '=' {
+			tok = l.readTwoCharToken(token.GT_EQ)
+		} else {
+			tok = newToken(token.GT, l.ch)
+		}
+	case '&':
+		if l.peekChar() == '&' {
+			tok = l.readTwoCharToken(token.AND)
+		} else {
+			tok = newToken(token.ILLEGAL, l.ch)
+		}
+	case '|':
+		if l.peekChar() == '|' {
+			tok = l.readTwoCharToken(token.OR)
+		} else {
+			tok = newToken(token.ILLEGAL, l.ch)
+		}
 	case ';':
 		tok = newToken(token.SEMICOLON, l.ch)
 	case ',':
@@ -92,27 +118,56 @@ func (l *Lexer) NextToken() token.Token {
 		if isLetter(l.ch) {
 			tok.Literal = l.readIdentifier()
 			tok.Type = token.LookupIdent(tok.Literal)
-			return tok
+			return l.withSpan(tok, start)
 		} else if isDigit(l.ch) {
-			tok.Type = token.INT
-			tok.Literal = l.readNumber()
-			return tok
+			tok.Literal, tok.Type = l.readNumber()
+			return l.withSpan(tok, start)
 		}
 		tok = newToken(token.ILLEGAL, l.ch)
 	}
 
 	l.readChar()
+	return l.withSpan(tok, start)
+}
+
+// readTwoCharToken reads the next character and returns a token of the given
+// type made of it and the current one
+func (l *Lexer) readTwoCharToken(tokenType token.TokenType) token.Token {
+	ch := l.ch
+	l.readChar()
+	return token.Token{Type: tokenType, Literal: string(ch) + string(l.ch)}
+}
+
+// withSpan sets the token's span from start to the current position
+func (l *Lexer) withSpan(tok token.Token, start token.Position) token.Token {
+	tok.Pos = start
+	tok.End = l.currentPosition()
 	return tok
 }
 
 func (l *Lexer) peekChar() byte {
-	if l.readPosition >= len(l.input) {
+	return l.peekCharN(1)
+}
+
+// peekCharN returns the character n places after the current one
+func (l *Lexer) peekCharN(n int) byte {
+	if l.readPosition+n-1 >= len(l.input) {
 		return 0
 	}
-	return l.input[l.readPosition]
+	return l.input[l.readPosition+n-1]
 }
 
 func (l *Lexer) readChar() {
+	if l.readPosition > len(l.input) {
+		return // already past the end of input
+	}
+
+	if l.ch == '\n' {
+		l.line++
+		l.column = 0
+	}
+	l.column++
+
 	if l.readPosition >= len(l.input) {
 		l.ch = 0
 	} else {
@@ -122,6 +177,15 @@ func (l *Lexer) readChar() {
 	l.readPosition++
 }
 
+func (l *Lexer) currentPosition() token.Position {
+	return token.Position{
+		Filename: l.filename,
+		Offset:   l.position,
+		Line:     l.line,
+		Column:   l.column,
+	}
+}
+
 func (l *Lexer) readIdentifier() string {
 	position := l.position
 	for isLetter(l.ch) {
@@ -130,12 +194,37 @@ func (l *Lexer) readIdentifier() string {
 	return l.input[position:l.position]
 }
 
-func (l *Lexer) readNumber() string {
+// readNumber reads an integer, or a float if the digits are followed by a
+// fraction or an exponent, e.g. 1.5, 2e10 or 3.0E-2
+func (l *Lexer) readNumber() (string, token.TokenType) {
 	position := l.position
+	tokenType := token.TokenType(token.INT)
+
+	l.readDigits()
+	if l.ch == '.' && isDigit(l.peekChar()) {
+		tokenType = token.FLOAT
+		l.readChar()
+		l.readDigits()
+	}
+	if l.ch == 'e' || l.ch == 'E' {
+		next := l.peekChar()
+		if isDigit(next) || (next == '+' || next == '-') && isDigit(l.peekCharN(2)) {
+			tokenType = token.FLOAT
+			l.readChar()
+			if next == '+' || next == '-' {
+				l.readChar()
+			}
+			l.readDigits()
+		}
+	}
+
+	return l.input[position:l.position], tokenType
+}
+
+func (l *Lexer) readDigits() {
 	for isDigit(l.ch) {
 		l.readChar()
 	}
-	return l.input[position:l.position]
 }
 
 func (l *Lexer) readString() (s string, err error) {
@@ -156,14 +245,16 @@ Outer:
 			case 't':
 				result.WriteByte('\t')
 			default:
+				// keep reading to the closing quote so lexing resumes after
+				// the string
 				err = errors.New("unknown escape sequence")
-				break Outer
 			}
 			l.readChar()
 		case '"':
 			break Outer
 		default:
-			if err = result.WriteByte(l.ch); err != nil {
+			if werr := result.WriteByte(l.ch); werr != nil {
+				err = werr
 				break Outer
 			}
 		}
@@ -164,3 +164,131 @@ macro(x, y) { x + y; };
 		})
 	}
 }
+
+func TestTokenPositions(t *testing.T) {
+	input := `let x = "a b";
+  fn(y) {
+	y
+}`
+	tests := []struct {
+		expectedLiteral string
+		expectedPos     string
+		expectedEnd     string
+	}{
+		{"let", "test.mk:1:1", "test.mk:1:4"},
+		{"x", "test.mk:1:5", "test.mk:1:6"},
+		{"=", "test.mk:1:7", "test.mk:1:8"},
+		{"a b", "test.mk:1:9", "test.mk:1:14"},
+		{";", "test.mk:1:14", "test.mk:1:15"},
+		{"fn", "test.mk:2:3", "test.mk:2:5"},
+		{"(", "test.mk:2:5", "test.mk:2:6"},
+		{"y", "test.mk:2:6", "test.mk:2:7"},
+		{")", "test.mk:2:7", "test.mk:2:8"},
+		{"{", "test.mk:2:9", "test.mk:2:10"},
+		{"y", "test.mk:3:2", "test.mk:3:3"},
+		{"}", "test.mk:4:1", "test.mk:4:2"},
+		{"", "test.mk:4:2", "test.mk:4:2"},
+		{"", "test.mk:4:2", "test.mk:4:2"},
+	}
+
+	l := NewWithFilename("test.mk", input)
+
+	for _, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("literal wrong. expected %q, got %q", tt.expectedLiteral, tok.Literal)
+		}
+		if pos := tok.Pos.String(); pos != tt.expectedPos {
+			t.Errorf("position of %q wrong. expected %s, got %s", tok.Literal, tt.expectedPos, pos)
+		}
+		if end := tok.End.String(); end != tt.expectedEnd {
+			t.Errorf("end of %q wrong. expected %s, got %s", tok.Literal, tt.expectedEnd, end)
+		}
+	}
+}
+
+func TestUnknownEscapeSequence(t *testing.T) {
+	l := New(`"a\qb" c`)
+
+	tok := l.NextToken()
+	if tok.Type != token.ILLEGAL {
+		t.Fatalf("tokentype wrong. expected %q, got %q", token.ILLEGAL, tok.Type)
+	}
+
+	// lexing resumes after the closing quote
+	tok = l.NextToken()
+	if tok.Type != token.IDENT || tok.Literal != "c" {
+		t.Fatalf("wrong token after string. expected IDENT c, got %s %s", tok.Type, tok.Literal)
+	}
+}
+
+func TestNumbers(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []token.Token
+	}{
+		{"42", []token.Token{{Type: token.INT, Literal: "42"}}},
+		{"3.14", []token.Token{{Type: token.FLOAT, Literal: "3.14"}}},
+		{"1e10", []token.Token{{Type: token.FLOAT, Literal: "1e10"}}},
+		{"2.5E-3", []token.Token{{Type: token.FLOAT, Literal: "2.5E-3"}}},
+		{"6e+2", []token.Token{{Type: token.FLOAT, Literal: "6e+2"}}},
+		{"1.", []token.Token{
+			{Type: token.INT, Literal: "1"},
+			{Type: token.ILLEGAL, Literal: "."},
+		}},
+		{"2e", []token.Token{
+			{Type: token.INT, Literal: "2"},
+			{Type: token.IDENT, Literal: "e"},
+		}},
+		{"3e-x", []token.Token{
+			{Type: token.INT, Literal: "3"},
+			{Type: token.IDENT, Literal: "e"},
+			{Type: token.MINUS, Literal: "-"},
+			{Type: token.IDENT, Literal: "x"},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			l := New(tt.input)
+			for _, expected := range tt.expected {
+				tok := l.NextToken()
+				if tok.Type != expected.Type || tok.Literal != expected.Literal {
+					t.Fatalf("wrong token. expected %s %q, got %s %q",
+						expected.Type, expected.Literal, tok.Type, tok.Literal)
+				}
+			}
+			if tok := l.NextToken(); tok.Type != token.EOF {
+				t.Fatalf("expected EOF, got %s %q", tok.Type, tok.Literal)
+			}
+		})
+	}
+}
+
+func TestTwoCharacterOperators(t *testing.T) {
+	input := `a <= b >= c && d || e & |`
+	expected := []token.Token{
+		{Type: token.IDENT, Literal: "a"},
+		{Type: token.LT_EQ, Literal: "<="},
+		{Type: token.IDENT, Literal: "b"},
+		{Type: token.GT_EQ, Literal: ">="},
+		{Type: token.IDENT, Literal: "c"},
+		{Type: token.AND, Literal: "&&"},
+		{Type: token.IDENT, Literal: "d"},
+		{Type: token.OR, Literal: "||"},
+		{Type: token.IDENT, Literal: "e"},
+		{Type: token.ILLEGAL, Literal: "&"},
+		{Type: token.ILLEGAL, Literal: "|"},
+		{Type: token.EOF, Literal: ""},
+	}
+
+	l := New(input)
+	for _, tt := range expected {
+		tok := l.NextToken()
+		if tok.Type != tt.Type || tok.Literal != tt.Literal {
+			t.Fatalf("wrong token. expected %s %q, got %s %q",
+				tt.Type, tt.Literal, tok.Type, tok.Literal)
+		}
+	}
+}
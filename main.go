@@ -1,11 +1,129 @@
 package main
 
 import (
+	"flag"
+	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 
+	"github.com/mikeraimondi/monkey/compiler"
+	"github.com/mikeraimondi/monkey/engine"
 	"github.com/mikeraimondi/monkey/repl"
 )
 
+const (
+	exitOK      = 0
+	exitFailure = 1
+	exitUsage   = 2
+)
+
+const usage = `usage: monkey [flags] [file [args...]]
+       monkey build [-O] [-o output] file
+       monkey disasm [-O] file
+
+With no file, monkey starts a REPL if standard input is a terminal, and
+otherwise runs the program read from standard input. A file of "-" also
+reads the program from standard input. Any args are available to the
+program as the array of strings "args". The program may be bytecode
+compiled by "monkey build".
+
+Flags:
+`
+
+var engineName = flag.String("engine", "vm",
+	"execute programs with `engine`: vm (bytecode VM), eval (tree-walking\n"+
+		"evaluator), or diff (both, reporting any difference in results or\n"+
+		"output)")
+
+var optimize = flag.Bool("O", false, "optimize the bytecode of programs run on the VM")
+
 func main() {
-	repl.Start(os.Stdin, os.Stdout)
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	os.Exit(start(flag.Args()))
+}
+
+func start(args []string) int {
+	if len(args) > 0 {
+		switch args[0] {
+		case "build":
+			return buildCommand(args[1:])
+		case "disasm":
+			return disasmCommand(args[1:])
+		}
+	}
+
+	eng, err := engine.New(*engineName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+	if *optimize {
+		optimizeEngine(eng)
+	}
+
+	if len(args) == 0 && isTerminal(os.Stdin) {
+		if err := repl.StartInteractive(eng, historyPath()); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitFailure
+		}
+		return exitOK
+	}
+
+	name := "<stdin>"
+	var src []byte
+	if len(args) == 0 || args[0] == "-" {
+		src, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		name = args[0]
+		src, err = ioutil.ReadFile(name)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+
+	var scriptArgs []string
+	if len(args) > 1 {
+		scriptArgs = args[1:]
+	}
+
+	if compiler.IsBytecode(src) {
+		return runBytecode(eng, src, scriptArgs, os.Stderr)
+	}
+	return run(eng, name, string(src), scriptArgs, os.Stderr)
+}
+
+// optimizeEngine makes eng optimize the programs it compiles, if it compiles
+// them
+func optimizeEngine(eng engine.Engine) {
+	switch eng := eng.(type) {
+	case *engine.VM:
+		eng.CompilerOptions = append(eng.CompilerOptions, compiler.WithOptimization())
+	case *engine.Differential:
+		optimizeEngine(eng.VM)
+	}
+}
+
+// historyPath returns the file REPL history is kept in, or "" if there is
+// no home directory to keep it in
+func historyPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".monkey_history")
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
 }
@@ -1,6 +1,15 @@
 package object
 
-import "fmt"
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+)
+
+// Output is where puts writes
+var Output io.Writer = os.Stdout
 
 var Builtins = []struct {
 	Name    string
@@ -30,7 +39,7 @@ var Builtins = []struct {
 		&Builtin{
 			Fn: func(args ...Object) Object {
 				for _, arg := range args {
-					fmt.Println(arg.Inspect())
+					fmt.Fprintln(Output, arg.Inspect())
 				}
 				return nil
 			},
@@ -125,6 +134,60 @@ var Builtins = []struct {
 		},
 		},
 	},
+	{
+		"int",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got %d. want 1", len(args))
+			}
+
+			switch arg := args[0].(type) {
+			case *Integer:
+				return arg
+			case *Float:
+				if math.IsNaN(arg.Value) || math.IsInf(arg.Value, 0) ||
+					arg.Value >= math.MaxInt64 || arg.Value < math.MinInt64 {
+					return newError("cannot convert %s to INTEGER", arg.Inspect())
+				}
+				return &Integer{Value: int64(arg.Value)}
+			case *String:
+				value, err := strconv.ParseInt(arg.Value, 0, 64)
+				if err != nil {
+					return newError("cannot convert %q to INTEGER", arg.Value)
+				}
+				return &Integer{Value: value}
+			default:
+				return newError("argument to `int` not supported. got %s",
+					args[0].Type())
+			}
+		},
+		},
+	},
+	{
+		"float",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got %d. want 1", len(args))
+			}
+
+			switch arg := args[0].(type) {
+			case *Integer:
+				return &Float{Value: float64(arg.Value)}
+			case *Float:
+				return arg
+			case *String:
+				value, err := strconv.ParseFloat(arg.Value, 64)
+				if err != nil {
+					return newError("cannot convert %q to FLOAT", arg.Value)
+				}
+				return &Float{Value: value}
+			default:
+				return newError("argument to `float` not supported. got %s",
+					args[0].Type())
+			}
+		},
+		},
+	},
 }
 
 func newError(format string, a ...interface{}) *Error {
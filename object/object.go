@@ -3,6 +3,8 @@ package object
 import (
 	"fmt"
 	"hash/fnv"
+	"math"
+	"strconv"
 	"strings"
 
 	"github.com/mikeraimondi/monkey/code"
@@ -16,9 +18,12 @@ type BuiltinFunction func(args ...Object) Object
 
 const (
 	INTEGER_OBJ           = "INTEGER"
+	FLOAT_OBJ             = "FLOAT"
 	BOOLEAN_OBJ           = "BOOLEAN"
 	NULL_OBJ              = "NULL"
 	RETURN_VALUE_OBJ      = "RETURN_VALUE"
+	BREAK_OBJ             = "BREAK"
+	CONTINUE_OBJ          = "CONTINUE"
 	ERROR_OBJ             = "ERROR"
 	FUNCTION_OBJ          = "FUNCTION"
 	STRING_OBJ            = "STRING"
@@ -29,6 +34,7 @@ const (
 	MACRO_OBJ             = "MACRO"
 	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION_OBJ"
 	CLOSURE_OBJ           = "CLOSURE"
+	CELL_OBJ              = "CELL"
 )
 
 type Object interface {
@@ -45,19 +51,47 @@ type HashKey struct {
 	Value uint64
 }
 
+// Integer doesn't cache its hash key, which is cheap to compute, so that it
+// holds no pointers and the VM can share small integers between goroutines
 type Integer struct {
 	Value int64
-	key   HashKey
 }
 
 func (i *Integer) Type() ObjectType { return INTEGER_OBJ }
 func (i *Integer) Inspect() string  { return fmt.Sprintf("%d", i.Value) }
 func (i *Integer) HashKey() HashKey {
-	if i.key.Type == "" {
-		i.key = HashKey{Type: i.Type(), Value: uint64(i.Value)}
+	return HashKey{Type: i.Type(), Value: uint64(i.Value)}
+}
+
+type Float struct {
+	Value float64
+	key   HashKey
+}
+
+func (f *Float) Type() ObjectType { return FLOAT_OBJ }
+
+// Inspect always includes a decimal point or exponent, so floats can be told
+// apart from integers
+func (f *Float) Inspect() string {
+	s := strconv.FormatFloat(f.Value, 'g', -1, 64)
+	if strings.ContainsAny(s, ".eIN") {
+		return s
+	}
+	return s + ".0"
+}
+
+// HashKey of a float with an integral value is that of the integer it
+// equals, so that either can be used to look up the other in a hash
+func (f *Float) HashKey() HashKey {
+	if f.key.Type == "" {
+		if f.Value == math.Trunc(f.Value) && f.Value >= math.MinInt64 && f.Value < math.MaxInt64 {
+			f.key = HashKey{Type: INTEGER_OBJ, Value: uint64(int64(f.Value))}
+		} else {
+			f.key = HashKey{Type: f.Type(), Value: math.Float64bits(f.Value)}
+		}
 	}
 
-	return i.key
+	return f.key
 }
 
 type Boolean struct {
@@ -94,6 +128,19 @@ type ReturnValue struct {
 func (rv *ReturnValue) Type() ObjectType { return RETURN_VALUE_OBJ }
 func (rv *ReturnValue) Inspect() string  { return rv.Value.Inspect() }
 
+// Break is the result of a break statement, on its way to the enclosing loop
+type Break struct{}
+
+func (b *Break) Type() ObjectType { return BREAK_OBJ }
+func (b *Break) Inspect() string  { return "break" }
+
+// Continue is the result of a continue statement, on its way to the
+// enclosing loop
+type Continue struct{}
+
+func (c *Continue) Type() ObjectType { return CONTINUE_OBJ }
+func (c *Continue) Inspect() string  { return "continue" }
+
 type Error struct {
 	Message string
 }
@@ -237,22 +284,62 @@ func (m *Macro) Inspect() string {
 }
 
 type CompiledFunction struct {
+	Name          string   // name the function was bound to by let, if any
+	Parameters    []string // names of the parameters
+	Locals        []string // names of the locals, by index
+	Free          []string // names of the free variables, by index
 	Instructions  code.Instructions
 	NumLocals     int
 	NumParameters int
+	SourceMap     code.SourceMap
 }
 
 func (cf *CompiledFunction) Type() ObjectType { return COMPILED_FUNCTION_OBJ }
+
+// Inspect returns the function's signature, e.g. fn fibonacci(x)
 func (cf *CompiledFunction) Inspect() string {
-	return fmt.Sprintf("CompiledFunction[%p]", cf)
+	out := ast.StringBuilder{}
+
+	out.MustWrite("fn")
+	if cf.Name != "" {
+		out.MustWrite(" ")
+		out.MustWrite(cf.Name)
+	}
+	out.MustWrite("(")
+	out.MustWrite(strings.Join(cf.Parameters, ", "))
+	out.MustWrite(")")
+
+	return out.String()
 }
 
 type Closure struct {
 	Fn   *CompiledFunction
-	Free []Object
+	Free []*Cell
 }
 
 func (c *Closure) Type() ObjectType { return CLOSURE_OBJ }
 func (c *Closure) Inspect() string {
-	return fmt.Sprintf("Closure[%p]", c)
+	return c.Fn.Inspect()
+}
+
+// Cell holds a variable captured by closures, so that they share it with the
+// function that defines it. While that function is running, Ref points to
+// the variable's slot on the VM stack; once it returns, Ref points to Value.
+type Cell struct {
+	Ref   *Object
+	Value Object
+}
+
+func (c *Cell) Type() ObjectType { return CELL_OBJ }
+func (c *Cell) Inspect() string {
+	if *c.Ref == nil {
+		return "cell()"
+	}
+	return "cell(" + (*c.Ref).Inspect() + ")"
+}
+
+// Close moves the variable from its stack slot into the cell
+func (c *Cell) Close() {
+	c.Value = *c.Ref
+	c.Ref = &c.Value
 }
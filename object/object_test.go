@@ -1,6 +1,9 @@
 package object
 
-import "testing"
+import (
+	"math"
+	"testing"
+)
 
 func TestStringHashKey(t *testing.T) {
 	hello1 := &String{Value: "Hello World"}
@@ -58,3 +61,85 @@ func TestIntegerHashKey(t *testing.T) {
 		t.Errorf("integers with different content have same hash keys")
 	}
 }
+
+func TestFloatHashKey(t *testing.T) {
+	half1 := &Float{Value: 0.5}
+	half2 := &Float{Value: 0.5}
+	one := &Float{Value: 1}
+
+	if half1.HashKey() != half2.HashKey() {
+		t.Errorf("floats with same content have different hash keys")
+	}
+
+	if half1.HashKey() == one.HashKey() {
+		t.Errorf("floats with different content have same hash keys")
+	}
+
+	// integral floats equal the integers of the same value
+	if one.HashKey() != (&Integer{Value: 1}).HashKey() {
+		t.Errorf("float and equal integer have different hash keys")
+	}
+
+	if half1.HashKey() == (&Integer{Value: 0}).HashKey() {
+		t.Errorf("float and truncated integer have same hash keys")
+	}
+
+	if (&Float{Value: math.Pow(2, 63)}).HashKey() == (&Integer{Value: math.MinInt64}).HashKey() {
+		t.Errorf("float out of integer range has integer hash key")
+	}
+}
+
+func TestFloatInspect(t *testing.T) {
+	tests := []struct {
+		value    float64
+		expected string
+	}{
+		{1.5, "1.5"},
+		{2, "2.0"},
+		{-3, "-3.0"},
+		{1e21, "1e+21"},
+		{math.Inf(1), "+Inf"},
+	}
+
+	for _, tt := range tests {
+		if actual := (&Float{Value: tt.value}).Inspect(); actual != tt.expected {
+			t.Errorf("wrong inspection. expected %q, got %q", tt.expected, actual)
+		}
+	}
+}
+
+func TestCompiledFunctionInspect(t *testing.T) {
+	tests := []struct {
+		fn       *CompiledFunction
+		expected string
+	}{
+		{&CompiledFunction{Name: "fibonacci", Parameters: []string{"x"}}, "fn fibonacci(x)"},
+		{&CompiledFunction{Parameters: []string{"a", "b"}}, "fn(a, b)"},
+		{&CompiledFunction{Name: "f"}, "fn f()"},
+	}
+
+	for _, tt := range tests {
+		if actual := tt.fn.Inspect(); actual != tt.expected {
+			t.Errorf("wrong inspection. expected %q, got %q", tt.expected, actual)
+		}
+		if actual := (&Closure{Fn: tt.fn}).Inspect(); actual != tt.expected {
+			t.Errorf("wrong closure inspection. expected %q, got %q", tt.expected, actual)
+		}
+	}
+}
+
+func TestCellClose(t *testing.T) {
+	slot := Object(&Integer{Value: 1})
+	cell := &Cell{Ref: &slot}
+
+	slot = &Integer{Value: 2}
+	if cell.Inspect() != "cell(2)" {
+		t.Errorf("open cell does not refer to its slot. got %s", cell.Inspect())
+	}
+
+	cell.Close()
+	slot = &Integer{Value: 3}
+	if cell.Inspect() != "cell(2)" {
+		t.Errorf("closed cell still refers to its slot. got %s", cell.Inspect())
+	}
+}
@@ -0,0 +1,121 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mikeraimondi/monkey/token"
+)
+
+// Severity is how serious a Diagnostic is
+type Severity int
+
+const (
+	// SeverityError means the program could not be parsed
+	SeverityError Severity = iota
+	// SeverityWarning means the program parsed but is suspicious
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	default:
+		return fmt.Sprintf("Severity(%d)", int(s))
+	}
+}
+
+// DiagnosticCode identifies the kind of problem a Diagnostic reports
+type DiagnosticCode string
+
+const (
+	// UnexpectedToken is reported when the next token is not the one required
+	UnexpectedToken DiagnosticCode = "unexpected-token"
+	// NoPrefixParseFn is reported when a token cannot begin an expression
+	NoPrefixParseFn DiagnosticCode = "no-prefix-parse-fn"
+	// IllegalToken is reported when the lexer could not make sense of its input
+	IllegalToken DiagnosticCode = "illegal-token"
+	// InvalidInteger is reported when an integer literal is out of range
+	InvalidInteger DiagnosticCode = "invalid-integer"
+	// InvalidFloat is reported when a float literal is out of range
+	InvalidFloat DiagnosticCode = "invalid-float"
+	// InvalidAssignment is reported when the left of = is not assignable
+	InvalidAssignment DiagnosticCode = "invalid-assignment"
+	// TooManyErrors is reported in place of the error after MaxErrors
+	TooManyErrors DiagnosticCode = "too-many-errors"
+)
+
+// Span is a range of source, from Start up to but not including End
+type Span struct {
+	Start token.Position
+	End   token.Position
+}
+
+// Diagnostic is a problem the parser found in its input
+type Diagnostic struct {
+	Severity Severity
+	Code     DiagnosticCode
+	Message  string
+	Span     Span
+
+	// Expected is the token type the parser required, if any
+	Expected token.TokenType
+	// Got is the token the parser found instead
+	Got token.Token
+}
+
+// String returns the diagnostic as "position: message"
+func (d Diagnostic) String() string {
+	return d.Span.Start.String() + ": " + d.Message
+}
+
+// Format returns the diagnostic as "position: severity: message", followed by
+// a snippet of source if one is available
+func (d Diagnostic) Format(source string) string {
+	msg := fmt.Sprintf("%s: %s: %s", d.Span.Start, d.Severity, d.Message)
+	if snippet := d.Snippet(source); snippet != "" {
+		msg += "\n" + snippet
+	}
+	return msg
+}
+
+// Snippet returns the source line the diagnostic refers to, followed by a
+// line of carets underlining its span
+func (d Diagnostic) Snippet(source string) string {
+	start := d.Span.Start.Offset
+	if !d.Span.Start.IsValid() || start > len(source) {
+		return ""
+	}
+
+	lineStart := strings.LastIndexByte(source[:start], '\n') + 1
+	lineEnd := len(source)
+	if i := strings.IndexByte(source[start:], '\n'); i >= 0 {
+		lineEnd = start + i
+	}
+
+	width := d.Span.End.Offset - start
+	if width > lineEnd-start {
+		width = lineEnd - start
+	}
+	if width < 1 {
+		width = 1
+	}
+
+	out := strings.Builder{}
+	out.WriteString(source[lineStart:lineEnd])
+	out.WriteByte('\n')
+	for _, ch := range []byte(source[lineStart:start]) {
+		// keep tabs so the carets line up with the source line
+		if ch == '\t' {
+			out.WriteByte('\t')
+		} else {
+			out.WriteByte(' ')
+		}
+	}
+	out.WriteString(strings.Repeat("^", width))
+
+	return out.String()
+}
@@ -13,13 +13,19 @@ const (
 	_ int = iota
 	// LOWEST is used for the initial call
 	LOWEST
+	// ASSIGNMENT is x = Y
+	ASSIGNMENT
+	// LOGICALOR is ||
+	LOGICALOR
+	// LOGICALAND is &&
+	LOGICALAND
 	// EQUALS is ==
 	EQUALS
-	// LESSGREATER is < or >
+	// LESSGREATER is <, >, <= or >=
 	LESSGREATER
 	// SUM is +
 	SUM
-	// PRODUCT is *
+	// PRODUCT is *, / or %
 	PRODUCT
 	// PREFIX is -X or !X
 	PREFIX
@@ -30,23 +36,41 @@ const (
 	INDEX
 )
 
+// MaxErrors is the number of errors after which the parser gives up
+const MaxErrors = 10
+
 var precedences = map[token.TokenType]int{
 	token.EQ:       EQUALS,
 	token.NOT_EQ:   EQUALS,
+	token.ASSIGN:   ASSIGNMENT,
+	token.OR:       LOGICALOR,
+	token.AND:      LOGICALAND,
 	token.LT:       LESSGREATER,
 	token.GT:       LESSGREATER,
+	token.LT_EQ:    LESSGREATER,
+	token.GT_EQ:    LESSGREATER,
 	token.PLUS:     SUM,
 	token.MINUS:    SUM,
 	token.SLASH:    PRODUCT,
 	token.ASTERISK: PRODUCT,
+	token.PERCENT:  PRODUCT,
 	token.LPAREN:   CALL,
 	token.LBRACKET: INDEX,
 }
 
 // Parser wraps a lexer and constructs an AST
 type Parser struct {
-	l      *lexer.Lexer
-	errors []string
+	l           *lexer.Lexer
+	diagnostics []Diagnostic
+	errorCount  int
+
+	// recovering is set once an error is reported, and suppresses further
+	// errors until the parser has skipped to the next statement
+	recovering bool
+	// halted is set once MaxErrors is reached
+	halted bool
+	// nesting counts the brackets, braces and parens open after curToken
+	nesting int
 
 	curToken  token.Token
 	peekToken token.Token
@@ -58,8 +82,8 @@ type Parser struct {
 // New returns an initialized Lexer
 func New(l *lexer.Lexer) *Parser {
 	p := &Parser{
-		l:      l,
-		errors: []string{},
+		l:           l,
+		diagnostics: []Diagnostic{},
 	}
 
 	// read two tokens so curToken and peekToken are both set
@@ -69,6 +93,7 @@ func New(l *lexer.Lexer) *Parser {
 	p.prefixParseFns = make(map[token.TokenType]prefixParseFn)
 	p.registerPrefix(token.IDENT, p.parseIdentifier)
 	p.registerPrefix(token.INT, p.parseIntegerLiteral)
+	p.registerPrefix(token.FLOAT, p.parseFloatLiteral)
 	p.registerPrefix(token.BANG, p.parsePrefixExpression)
 	p.registerPrefix(token.MINUS, p.parsePrefixExpression)
 	p.registerPrefix(token.TRUE, p.parseBoolean)
@@ -86,10 +111,16 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.MINUS, p.parseInfixExpression)
 	p.registerInfix(token.SLASH, p.parseInfixExpression)
 	p.registerInfix(token.ASTERISK, p.parseInfixExpression)
+	p.registerInfix(token.PERCENT, p.parseInfixExpression)
 	p.registerInfix(token.EQ, p.parseInfixExpression)
 	p.registerInfix(token.NOT_EQ, p.parseInfixExpression)
 	p.registerInfix(token.LT, p.parseInfixExpression)
 	p.registerInfix(token.GT, p.parseInfixExpression)
+	p.registerInfix(token.LT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.GT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.AND, p.parseInfixExpression)
+	p.registerInfix(token.OR, p.parseInfixExpression)
+	p.registerInfix(token.ASSIGN, p.parseAssignExpression)
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
 	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
 
@@ -101,8 +132,8 @@ func (p *Parser) ParseProgram() *ast.Program {
 	program := &ast.Program{}
 	program.Statements = []ast.Statement{}
 
-	for !p.curTokenIs(token.EOF) {
-		stmt := p.parseStatement()
+	for !p.curTokenIs(token.EOF) && !p.halted {
+		stmt := p.parseRecoverableStatement()
 		if stmt != nil {
 			program.Statements = append(program.Statements, stmt)
 		}
@@ -112,22 +143,115 @@ func (p *Parser) ParseProgram() *ast.Program {
 	return program
 }
 
-// Errors returns any errors the parser encountered during parsing
+// Errors returns any errors the parser encountered during parsing, formatted
+// as strings
 func (p *Parser) Errors() []string {
-	return p.errors
+	errors := []string{}
+	for _, d := range p.diagnostics {
+		if d.Severity == SeverityError {
+			errors = append(errors, d.String())
+		}
+	}
+	return errors
+}
+
+// Diagnostics returns everything the parser reported during parsing
+func (p *Parser) Diagnostics() []Diagnostic {
+	return p.diagnostics
 }
 
 func (p *Parser) nextToken() {
 	p.curToken = p.peekToken
 	p.peekToken = p.l.NextToken()
+
+	switch {
+	case isOpening(p.curToken.Type):
+		p.nesting++
+	case isClosing(p.curToken.Type) && p.nesting > 0:
+		p.nesting--
+	}
+}
+
+// parseRecoverableStatement parses a statement. If that fails, it skips to
+// the end of the statement so that parsing can resume with the next one, and
+// returns nil.
+func (p *Parser) parseRecoverableStatement() ast.Statement {
+	if p.recovering {
+		// an enclosing statement has already failed and will be skipped
+		return p.parseStatement()
+	}
+
+	level := p.nesting
+	if isOpening(p.curToken.Type) {
+		level--
+	}
+
+	stmt := p.parseStatement()
+	if !p.recovering {
+		return stmt
+	}
+
+	p.synchronize(level)
+	p.recovering = false
+	return nil
+}
+
+// synchronize skips tokens until curToken ends the statement that began at
+// the given nesting level, or peekToken begins the next one
+func (p *Parser) synchronize(level int) {
+	for !p.curTokenIs(token.EOF) {
+		if p.nesting < level {
+			return
+		}
+		if p.nesting == level {
+			if p.curTokenIs(token.SEMICOLON) {
+				return
+			}
+			switch p.peekToken.Type {
+			case token.LET, token.RETURN, token.WHILE, token.FOR, token.EOF:
+				return
+			case token.RBRACE:
+				if level > 0 {
+					// the brace closes the block the statement is in
+					return
+				}
+			}
+		}
+		p.nextToken()
+	}
 }
 
 func (p *Parser) parseStatement() ast.Statement {
 	switch p.curToken.Type {
 	case token.LET:
-		return p.parseLetStatement()
+		if stmt := p.parseLetStatement(); stmt != nil {
+			return stmt
+		}
+		return nil
 	case token.RETURN:
 		return p.parseReturnStatement()
+	case token.WHILE:
+		if stmt := p.parseWhileStatement(); stmt != nil {
+			return stmt
+		}
+		return nil
+	case token.FOR:
+		if stmt := p.parseForStatement(); stmt != nil {
+			return stmt
+		}
+		return nil
+	case token.BREAK:
+		stmt := &ast.BreakStatement{Token: p.curToken}
+		if p.peekTokenIs(token.SEMICOLON) {
+			p.nextToken()
+		}
+		return stmt
+	case token.CONTINUE:
+		stmt := &ast.ContinueStatement{Token: p.curToken}
+		if p.peekTokenIs(token.SEMICOLON) {
+			p.nextToken()
+		}
+		return stmt
 	default:
 		return p.parseExpressionStatement()
 	}
@@ -153,6 +277,9 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 	p.nextToken()
 
 	stmt.Value = p.parseExpression(LOWEST)
+	if fl, ok := stmt.Value.(*ast.FunctionLiteral); ok {
+		fl.Name = stmt.Name.Value
+	}
 
 	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
@@ -175,6 +302,70 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	return stmt
 }
 
+func (p *Parser) parseWhileStatement() *ast.WhileStatement {
+	stmt := &ast.WhileStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	p.nextToken()
+	stmt.Condition = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	stmt.Body = p.parseBlockStatement()
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseForStatement() *ast.ForStatement {
+	stmt := &ast.ForStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+
+	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	if !p.expectPeek(token.IN) {
+		return nil
+	}
+
+	p.nextToken()
+	stmt.Iterable = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	stmt.Body = p.parseBlockStatement()
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
 func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 	stmt := &ast.ExpressionStatement{Token: p.curToken}
 
@@ -236,6 +427,26 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	return expression
 }
 
+func (p *Parser) parseAssignExpression(target ast.Expression) ast.Expression {
+	expression := &ast.AssignExpression{Token: p.curToken, Target: target}
+
+	switch target.(type) {
+	case *ast.Identifier, *ast.IndexExpression:
+	case nil:
+		return nil // the error has been reported
+	default:
+		p.addError(InvalidAssignment, p.curToken, "",
+			"cannot assign to %s", target.String())
+		return nil
+	}
+
+	p.nextToken()
+	// assignment is right-associative: a = b = c is a = (b = c)
+	expression.Value = p.parseExpression(ASSIGNMENT - 1)
+
+	return expression
+}
+
 func (p *Parser) parseGroupedExpression() ast.Expression {
 	p.nextToken()
 
@@ -293,8 +504,8 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 
 	p.nextToken()
 
-	for !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) {
-		stmt := p.parseStatement()
+	for !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) && !p.halted {
+		stmt := p.parseRecoverableStatement()
 		if stmt != nil {
 			block.Statements = append(block.Statements, stmt)
 		}
@@ -333,8 +544,23 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 
 	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
 	if err != nil {
-		msg := fmt.Sprintf("could not parse %q as integer", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
+		p.addError(InvalidInteger, p.curToken, "",
+			"could not parse %q as integer", p.curToken.Literal)
+		return nil
+	}
+
+	lit.Value = value
+
+	return lit
+}
+
+func (p *Parser) parseFloatLiteral() ast.Expression {
+	lit := &ast.FloatLiteral{Token: p.curToken}
+
+	value, err := strconv.ParseFloat(p.curToken.Literal, 64)
+	if err != nil {
+		p.addError(InvalidFloat, p.curToken, "",
+			"could not parse %q as float", p.curToken.Literal)
 		return nil
 	}
 
@@ -482,10 +708,10 @@ func (p *Parser) expectPeek(t token.TokenType) bool {
 }
 
 func (p *Parser) peekError(t token.TokenType) {
-	msg := fmt.Sprintf("expected next token to be %s, got %s instead",
+	p.addError(UnexpectedToken, p.peekToken, t,
+		"expected next token to be %s, got %s instead",
 		t,
 		p.peekToken.Type)
-	p.errors = append(p.errors, msg)
 }
 
 func (p *Parser) peekPrecedence() int {
@@ -505,8 +731,42 @@ func (p *Parser) curPrecedence() int {
 }
 
 func (p *Parser) noPrefixParseFnError(t token.TokenType) {
-	msg := fmt.Sprintf("no prefix parse function for %s found", t)
-	p.errors = append(p.errors, msg)
+	if t == token.ILLEGAL {
+		p.addError(IllegalToken, p.curToken, "", "illegal token %q", p.curToken.Literal)
+		return
+	}
+	p.addError(NoPrefixParseFn, p.curToken, "", "no prefix parse function for %s found", t)
+}
+
+// addError records an error diagnostic spanning got, unless the parser is
+// still recovering from a previous error
+func (p *Parser) addError(
+	code DiagnosticCode,
+	got token.Token,
+	expected token.TokenType,
+	format string,
+	a ...interface{},
+) {
+	if p.recovering || p.halted {
+		return
+	}
+	p.recovering = true
+
+	if p.errorCount == MaxErrors {
+		p.halted = true
+		code, expected = TooManyErrors, ""
+		format, a = "too many errors", nil
+	}
+	p.errorCount++
+
+	p.diagnostics = append(p.diagnostics, Diagnostic{
+		Severity: SeverityError,
+		Code:     code,
+		Message:  fmt.Sprintf(format, a...),
+		Span:     Span{Start: got.Pos, End: got.End},
+		Expected: expected,
+		Got:      got,
+	})
 }
 
 func (p *Parser) registerPrefix(tokenType token.TokenType, fn prefixParseFn) {
@@ -517,6 +777,14 @@ func (p *Parser) registerInfix(tokenType token.TokenType, fn infixParseFn) {
 	p.infixParseFns[tokenType] = fn
 }
 
+func isOpening(t token.TokenType) bool {
+	return t == token.LPAREN || t == token.LBRACE || t == token.LBRACKET
+}
+
+func isClosing(t token.TokenType) bool {
+	return t == token.RPAREN || t == token.RBRACE || t == token.RBRACKET
+}
+
 type (
 	prefixParseFn func() ast.Expression
 	infixParseFn  func(ast.Expression) ast.Expression
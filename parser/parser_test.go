@@ -3,10 +3,12 @@ package parser
 import (
 	"fmt"
 	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/mikeraimondi/monkey/ast"
 	"github.com/mikeraimondi/monkey/lexer"
+	"github.com/mikeraimondi/monkey/token"
 )
 
 func TestLetStatements(t *testing.T) {
@@ -140,6 +142,60 @@ func TestIntegerLiteralExpression(t *testing.T) {
 	}
 }
 
+func TestFloatLiteralExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"3.25", 3.25},
+		{"1e3", 1000},
+		{"2.5e-1", 0.25},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			l := lexer.New(tt.input)
+			p := New(l)
+			program := p.ParseProgram()
+			checkParserErrors(t, p)
+
+			if l := len(program.Statements); l != 1 {
+				t.Fatalf("program does not have enough statements. got %d", l)
+			}
+			stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+			if !ok {
+				t.Fatalf("program.Statements[0] is not ast.ExpressionStatement. got %T",
+					program.Statements[0])
+			}
+
+			literal, ok := stmt.Expression.(*ast.FloatLiteral)
+			if !ok {
+				t.Fatalf("exp not *ast.FloatLiteral. got %T", stmt.Expression)
+			}
+			if literal.Value != tt.expected {
+				t.Fatalf("literal.Value not %g. got %g", tt.expected, literal.Value)
+			}
+			if tl := literal.TokenLiteral(); tl != tt.input {
+				t.Errorf("literal.TokenLiteral not %s. got %s", tt.input, tl)
+			}
+		})
+	}
+}
+
+func TestInvalidAssignment(t *testing.T) {
+	l := lexer.New("f(x) = 1")
+	p := New(l)
+	p.ParseProgram()
+
+	diagnostics := p.Diagnostics()
+	if len(diagnostics) != 1 {
+		t.Fatalf("wrong number of diagnostics. expected 1, got %d", len(diagnostics))
+	}
+	if d := diagnostics[0]; d.Code != InvalidAssignment || d.String() != "1:6: cannot assign to f(x)" {
+		t.Errorf("wrong diagnostic. got %s %q", d.Code, d.String())
+	}
+}
+
 func TestFunctionLiteralParsing(t *testing.T) {
 	input := `fn(x, y) { x + y; }`
 
@@ -184,6 +240,34 @@ func TestFunctionLiteralParsing(t *testing.T) {
 	testInfixExpression(t, bodyStmt.Expression, "x", "+", "y")
 }
 
+func TestFunctionLiteralWithName(t *testing.T) {
+	input := `let myFunction = fn() { };`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if l := len(program.Statements); l != 1 {
+		t.Fatalf("program.Statements does not contain %d statements. got %d", 1, l)
+	}
+
+	stmt, ok := program.Statements[0].(*ast.LetStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.LetStatement. got %T",
+			program.Statements[0])
+	}
+
+	function, ok := stmt.Value.(*ast.FunctionLiteral)
+	if !ok {
+		t.Fatalf("stmt.Value is not ast.FunctionLiteral. got %T", stmt.Value)
+	}
+
+	if function.Name != "myFunction" {
+		t.Fatalf("function literal name wrong. want 'myFunction', got %q", function.Name)
+	}
+}
+
 func TestFunctionParameterParsing(t *testing.T) {
 	tests := []struct {
 		input          string
@@ -253,6 +337,91 @@ func TestCallExpressionParsing(t *testing.T) {
 	testInfixExpression(t, exp.Arguments[2], 4, "+", 5)
 }
 
+func TestWhileStatement(t *testing.T) {
+	input := `while (x < y) { x; break; continue; }`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if l := len(program.Statements); l != 1 {
+		t.Fatalf("program.Statements does not contain %d statements. got %d", 1, l)
+	}
+
+	stmt, ok := program.Statements[0].(*ast.WhileStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.WhileStatement. got %T",
+			program.Statements[0])
+	}
+
+	if !testInfixExpression(t, stmt.Condition, "x", "<", "y") {
+		return
+	}
+
+	if l := len(stmt.Body.Statements); l != 3 {
+		t.Fatalf("body is not 3 statements. got %d", l)
+	}
+	if _, ok := stmt.Body.Statements[1].(*ast.BreakStatement); !ok {
+		t.Errorf("body.Statements[1] is not ast.BreakStatement. got %T",
+			stmt.Body.Statements[1])
+	}
+	if _, ok := stmt.Body.Statements[2].(*ast.ContinueStatement); !ok {
+		t.Errorf("body.Statements[2] is not ast.ContinueStatement. got %T",
+			stmt.Body.Statements[2])
+	}
+}
+
+func TestForStatement(t *testing.T) {
+	input := `for (x in [1, 2]) { x }`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if l := len(program.Statements); l != 1 {
+		t.Fatalf("program.Statements does not contain %d statements. got %d", 1, l)
+	}
+
+	stmt, ok := program.Statements[0].(*ast.ForStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.ForStatement. got %T",
+			program.Statements[0])
+	}
+
+	if !testIdentifier(t, stmt.Name, "x") {
+		return
+	}
+	if _, ok := stmt.Iterable.(*ast.ArrayLiteral); !ok {
+		t.Errorf("stmt.Iterable is not ast.ArrayLiteral. got %T", stmt.Iterable)
+	}
+	if l := len(stmt.Body.Statements); l != 1 {
+		t.Fatalf("body is not 1 statement. got %d", l)
+	}
+	if s := stmt.String(); s != "for(x in [1, 2]) x" {
+		t.Errorf("stmt.String() wrong. got %q", s)
+	}
+}
+
+func TestLoopStatementSemicolons(t *testing.T) {
+	tests := []string{
+		`while (x) { x }; y`,
+		`for (x in y) { x }; y`,
+	}
+
+	for _, input := range tests {
+		l := lexer.New(input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if l := len(program.Statements); l != 2 {
+			t.Fatalf("program.Statements does not contain %d statements. got %d", 2, l)
+		}
+	}
+}
+
 func TestIfExpression(t *testing.T) {
 	input := `if (x < y) { x }`
 
@@ -508,6 +677,30 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"a + b / c",
 			"(a + (b / c))",
 		},
+		{
+			"a + b % c * d",
+			"(a + ((b % c) * d))",
+		},
+		{
+			"a <= b == c >= d",
+			"((a <= b) == (c >= d))",
+		},
+		{
+			"a || b && c == d",
+			"(a || (b && (c == d)))",
+		},
+		{
+			"a && b || c && d",
+			"((a && b) || (c && d))",
+		},
+		{
+			"x = y || z",
+			"(x = (y || z))",
+		},
+		{
+			"a[1] = b = 2 * c",
+			"((a[1]) = (b = (2 * c)))",
+		},
 		{
 			"a + b * c + d / e - f",
 			"(((a + (b * c)) + (d / e)) - f)",
@@ -944,3 +1137,187 @@ func TestMacroLiteralParsing(t *testing.T) {
 
 	testInfixExpression(t, bodyStmt.Expression, "x", "+", "y")
 }
+
+func TestParserErrorPositions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let = 5;", "1:5: expected next token to be IDENT, got = instead"},
+		{"let x = 5;\nlet y 6;", "2:7: expected next token to be =, got INT instead"},
+		{"1 +\n\n  ;", "3:3: no prefix parse function for ; found"},
+		{"99999999999999999999", "1:1: could not parse \"99999999999999999999\" as integer"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			p := New(lexer.New(tt.input))
+			p.ParseProgram()
+
+			errors := p.Errors()
+			if len(errors) == 0 {
+				t.Fatalf("expected parser errors, got none")
+			}
+			if errors[0] != tt.expected {
+				t.Errorf("wrong error. expected %q, got %q", tt.expected, errors[0])
+			}
+		})
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	tests := []struct {
+		input            string
+		expectedCode     DiagnosticCode
+		expectedExpected token.TokenType
+		expectedGot      token.TokenType
+		expectedSpan     string
+	}{
+		{"let = 5;", UnexpectedToken, token.IDENT, token.ASSIGN, "1:5-1:6"},
+		{"if (x) 1", UnexpectedToken, token.LBRACE, token.INT, "1:8-1:9"},
+		{"1 + ;", NoPrefixParseFn, "", token.SEMICOLON, "1:5-1:6"},
+		{`"unterminated`, IllegalToken, "", token.ILLEGAL, "1:1-1:14"},
+		{"99999999999999999999", InvalidInteger, "", token.INT, "1:1-1:21"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			p := New(lexer.New(tt.input))
+			p.ParseProgram()
+
+			diagnostics := p.Diagnostics()
+			if len(diagnostics) == 0 {
+				t.Fatalf("expected diagnostics, got none")
+			}
+
+			d := diagnostics[0]
+			if d.Severity != SeverityError {
+				t.Errorf("wrong severity. expected %s, got %s", SeverityError, d.Severity)
+			}
+			if d.Code != tt.expectedCode {
+				t.Errorf("wrong code. expected %s, got %s", tt.expectedCode, d.Code)
+			}
+			if d.Expected != tt.expectedExpected {
+				t.Errorf("wrong expected token. expected %q, got %q",
+					tt.expectedExpected, d.Expected)
+			}
+			if d.Got.Type != tt.expectedGot {
+				t.Errorf("wrong got token. expected %q, got %q", tt.expectedGot, d.Got.Type)
+			}
+			if span := d.Span.Start.String() + "-" + d.Span.End.String(); span != tt.expectedSpan {
+				t.Errorf("wrong span. expected %s, got %s", tt.expectedSpan, span)
+			}
+		})
+	}
+}
+
+func TestDiagnosticSnippet(t *testing.T) {
+	input := "let a = 1;\n\tlet b == 2;\nb"
+
+	p := New(lexer.New(input))
+	p.ParseProgram()
+
+	diagnostics := p.Diagnostics()
+	if len(diagnostics) == 0 {
+		t.Fatalf("expected diagnostics, got none")
+	}
+
+	expected := "\tlet b == 2;\n\t      ^^"
+	if snippet := diagnostics[0].Snippet(input); snippet != expected {
+		t.Errorf("wrong snippet.\nexpected %q\ngot %q", expected, snippet)
+	}
+}
+
+func TestErrorRecovery(t *testing.T) {
+	tests := []struct {
+		input              string
+		expectedErrors     []string
+		expectedStatements []string
+	}{
+		{
+			"let x 5; let y = 10; y",
+			[]string{"1:7: expected next token to be =, got INT instead"},
+			[]string{"let y = 10;", "y"},
+		},
+		{
+			"let = 5;\n1 + ;\nlet z = 3;",
+			[]string{
+				"1:5: expected next token to be IDENT, got = instead",
+				"2:5: no prefix parse function for ; found",
+			},
+			[]string{"let z = 3;"},
+		},
+		{
+			"let f = fn(x y) { x }; f(1)",
+			[]string{"1:14: expected next token to be ), got IDENT instead"},
+			[]string{"f(1)"},
+		},
+		{
+			`{"a" 1}; 2`,
+			[]string{"1:6: expected next token to be :, got INT instead"},
+			[]string{"2"},
+		},
+		{
+			"let f = fn() {\n  let = 1;\n  {\"a\" 1};\n  2\n};\nf()",
+			[]string{
+				"2:7: expected next token to be IDENT, got = instead",
+				"3:8: expected next token to be :, got INT instead",
+			},
+			[]string{"let f = fn()2;", "f()"},
+		},
+		{
+			"if (x) { 1 } else 2; let a = 1",
+			[]string{"1:19: expected next token to be {, got INT instead"},
+			[]string{"let a = 1;"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			p := New(lexer.New(tt.input))
+			program := p.ParseProgram()
+
+			errors := p.Errors()
+			if len(errors) != len(tt.expectedErrors) {
+				t.Fatalf("wrong number of errors. expected %d, got %d: %q",
+					len(tt.expectedErrors), len(errors), errors)
+			}
+			for i, expected := range tt.expectedErrors {
+				if errors[i] != expected {
+					t.Errorf("wrong error %d. expected %q, got %q", i, expected, errors[i])
+				}
+			}
+
+			if len(program.Statements) != len(tt.expectedStatements) {
+				t.Fatalf("wrong number of statements. expected %d, got %d: %q",
+					len(tt.expectedStatements), len(program.Statements), program.String())
+			}
+			for i, expected := range tt.expectedStatements {
+				if actual := program.Statements[i].String(); actual != expected {
+					t.Errorf("wrong statement %d. expected %q, got %q", i, expected, actual)
+				}
+			}
+		})
+	}
+}
+
+func TestMaxErrors(t *testing.T) {
+	input := strings.Repeat("let = 1;\n", MaxErrors+5)
+
+	p := New(lexer.New(input))
+	p.ParseProgram()
+
+	diagnostics := p.Diagnostics()
+	if len(diagnostics) != MaxErrors+1 {
+		t.Fatalf("wrong number of diagnostics. expected %d, got %d",
+			MaxErrors+1, len(diagnostics))
+	}
+
+	last := diagnostics[MaxErrors]
+	if last.Code != TooManyErrors {
+		t.Errorf("wrong code for last diagnostic. expected %s, got %s", TooManyErrors, last.Code)
+	}
+	if expected := fmt.Sprintf("%d:5: too many errors", MaxErrors+1); last.String() != expected {
+		t.Errorf("wrong last diagnostic. expected %q, got %q", expected, last.String())
+	}
+}
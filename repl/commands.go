@@ -0,0 +1,165 @@
+package repl
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/mikeraimondi/monkey/ast"
+	"github.com/mikeraimondi/monkey/compiler"
+	"github.com/mikeraimondi/monkey/engine"
+	"github.com/mikeraimondi/monkey/object"
+)
+
+type command struct {
+	args string
+	help string
+	run  func(s *session, arg string)
+}
+
+var commands map[string]command
+
+func init() {
+	// assigned here because :help refers to commands
+	commands = map[string]command{
+		"help":      {"", "list commands", (*session).help},
+		"globals":   {"", "list global bindings and their values", (*session).globals},
+		"constants": {"", "list the constant pool (vm engine)", (*session).constants},
+		"disasm":    {"<expr>", "show the bytecode for expr without running it (vm engine)", (*session).disasm},
+		"ast":       {"<expr>", "show the syntax tree of expr", (*session).syntaxTree},
+		"macros":    {"", "list defined macros", (*session).macros},
+		"reset":     {"", "discard all bindings, constants and macros", (*session).reset},
+		"load":      {"<file>", "run a file in this session", (*session).load},
+		"time":      {"", "toggle printing how long each input takes to run", (*session).toggleTiming},
+	}
+}
+
+// runCommand runs an input of the form ":name arg"
+func (s *session) runCommand(input string) {
+	name := strings.TrimPrefix(input, ":")
+	arg := ""
+	if i := strings.IndexAny(name, " \t\n"); i >= 0 {
+		name, arg = name[:i], strings.TrimSpace(name[i:])
+	}
+
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(s.out, "unknown command :%s. try :help\n", name)
+		return
+	}
+	if cmd.args != "" && arg == "" {
+		fmt.Fprintf(s.out, "usage: :%s %s\n", name, cmd.args)
+		return
+	}
+
+	cmd.run(s, arg)
+}
+
+func (s *session) help(string) {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		cmd := commands[name]
+		usage := strings.TrimSpace(":" + name + " " + cmd.args)
+		fmt.Fprintf(s.out, "%-16s %s\n", usage, cmd.help)
+	}
+}
+
+func (s *session) globals(string) {
+	for _, b := range s.eng.Globals() {
+		fmt.Fprintf(s.out, "%s = %s\n", b.Name, inspect(b.Value))
+	}
+}
+
+func (s *session) constants(string) {
+	vm, ok := s.vm()
+	if !ok {
+		return
+	}
+	for i, c := range vm.Constants {
+		fmt.Fprintf(s.out, "%d: %s %s\n", i, c.Type(), inspect(c))
+	}
+}
+
+func (s *session) disasm(arg string) {
+	vm, ok := s.vm()
+	if !ok {
+		return
+	}
+
+	program, ok := s.parse("", arg)
+	if !ok {
+		return
+	}
+
+	bytecode, err := vm.Compile(program)
+	if err != nil {
+		fmt.Fprintf(s.out, "%s\n", err)
+		return
+	}
+
+	fmt.Fprint(s.out, compiler.Disassemble(bytecode, vm.SymbolTable))
+}
+
+func (s *session) syntaxTree(arg string) {
+	program, ok := s.parse("", arg)
+	if !ok {
+		return
+	}
+	fmt.Fprint(s.out, ast.Dump(program))
+}
+
+func (s *session) macros(string) {
+	for _, name := range s.macroEnv.Names() {
+		macro, _ := s.macroEnv.Get(name)
+		fmt.Fprintf(s.out, "%s = %s\n", name, macro.Inspect())
+	}
+}
+
+func (s *session) reset(string) {
+	s.eng.Reset()
+	s.macroEnv = object.NewEnvironment()
+}
+
+func (s *session) load(arg string) {
+	src, err := ioutil.ReadFile(arg)
+	if err != nil {
+		fmt.Fprintf(s.out, "%s\n", err)
+		return
+	}
+	s.run(arg, string(src))
+}
+
+func (s *session) toggleTiming(string) {
+	s.timing = !s.timing
+	if s.timing {
+		fmt.Fprintln(s.out, "timing on")
+	} else {
+		fmt.Fprintln(s.out, "timing off")
+	}
+}
+
+// vm returns the session's VM engine, or prints why there isn't one
+func (s *session) vm() (*engine.VM, bool) {
+	switch eng := s.eng.(type) {
+	case *engine.VM:
+		return eng, true
+	case *engine.Differential:
+		return eng.VM, true
+	default:
+		fmt.Fprintln(s.out, "only available with the vm or diff engine")
+		return nil, false
+	}
+}
+
+func inspect(obj object.Object) string {
+	if obj == nil {
+		return "<unset>"
+	}
+	return obj.Inspect()
+}
@@ -0,0 +1,49 @@
+package repl
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/mikeraimondi/monkey/object"
+	"github.com/mikeraimondi/monkey/token"
+)
+
+// complete completes the word before pos in line with a keyword, builtin,
+// global or, at the start of the line, a command
+func (s *session) complete(line string, pos int) (head string, completions []string, tail string) {
+	start := pos
+	for start > 0 && isWordChar(line[start-1]) {
+		start--
+	}
+	head, word, tail := line[:start], line[start:pos], line[pos:]
+
+	candidates := []string{}
+	if strings.TrimSpace(head) == ":" {
+		for name := range commands {
+			candidates = append(candidates, name)
+		}
+	} else {
+		candidates = append(candidates, token.Keywords()...)
+		for _, b := range object.Builtins {
+			candidates = append(candidates, b.Name)
+		}
+		for _, b := range s.eng.Globals() {
+			candidates = append(candidates, b.Name)
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, c := range candidates {
+		if strings.HasPrefix(c, word) && !seen[c] {
+			seen[c] = true
+			completions = append(completions, c)
+		}
+	}
+	sort.Strings(completions)
+
+	return head, completions, tail
+}
+
+func isWordChar(ch byte) bool {
+	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_'
+}
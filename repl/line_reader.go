@@ -0,0 +1,102 @@
+package repl
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/peterh/liner"
+
+	"github.com/mikeraimondi/monkey/engine"
+)
+
+// errInputAborted is returned by a lineReader when the user cancels the
+// input being typed
+var errInputAborted = errors.New("input aborted")
+
+// lineReader reads input a line at a time
+type lineReader interface {
+	// readLine shows prompt and returns the next line, without its newline
+	readLine(prompt string) (string, error)
+	// addHistory records a complete input so it can be recalled
+	addHistory(input string)
+}
+
+// scannerReader reads lines from any io.Reader
+type scannerReader struct {
+	scanner *bufio.Scanner
+	out     io.Writer
+}
+
+func (r *scannerReader) readLine(prompt string) (string, error) {
+	fmt.Fprint(r.out, prompt)
+	if !r.scanner.Scan() {
+		if err := r.scanner.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+	return r.scanner.Text(), nil
+}
+
+func (r *scannerReader) addHistory(string) {}
+
+// linerReader reads lines from the terminal with line editing
+type linerReader struct {
+	state *liner.State
+}
+
+func (r *linerReader) readLine(prompt string) (string, error) {
+	line, err := r.state.Prompt(prompt)
+	if err == liner.ErrPromptAborted {
+		return "", errInputAborted
+	}
+	return line, err
+}
+
+func (r *linerReader) addHistory(input string) {
+	// history entries are single lines
+	r.state.AppendHistory(strings.Replace(input, "\n", " ", -1))
+}
+
+// StartInteractive runs a REPL on the terminal, with line editing, history
+// and tab completion. History is loaded from and saved to historyPath,
+// unless it is empty.
+func StartInteractive(eng engine.Engine, historyPath string) error {
+	state := liner.NewLiner()
+	defer state.Close()
+
+	state.SetCtrlCAborts(true)
+	state.SetTabCompletionStyle(liner.TabPrints)
+
+	if historyPath != "" {
+		if f, err := os.Open(historyPath); err == nil {
+			_, err = state.ReadHistory(f)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	s := newSession(os.Stdout, eng)
+	state.SetWordCompleter(s.complete)
+	s.loop(&linerReader{state: state})
+
+	if historyPath == "" {
+		return nil
+	}
+
+	f, err := os.Create(historyPath)
+	if err != nil {
+		return err
+	}
+	if _, err := state.WriteHistory(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -5,76 +5,173 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"strings"
+	"time"
 
-	"github.com/mikeraimondi/monkey/compiler"
+	"github.com/mikeraimondi/monkey/ast"
+	"github.com/mikeraimondi/monkey/engine"
 	"github.com/mikeraimondi/monkey/evaluator"
 	"github.com/mikeraimondi/monkey/lexer"
 	"github.com/mikeraimondi/monkey/object"
 	"github.com/mikeraimondi/monkey/parser"
-	"github.com/mikeraimondi/monkey/vm"
+	"github.com/mikeraimondi/monkey/token"
 )
 
 const PROMPT = ">> "
 
-func Start(in io.Reader, out io.Writer) {
-	scanner := bufio.NewScanner(in)
+// CONTINUATION_PROMPT is shown while reading the rest of an incomplete input
+const CONTINUATION_PROMPT = ".. "
 
-	macroEnv := object.NewEnvironment()
-	constants := []object.Object{}
-	globals := make([]object.Object, vm.GlobalsSize)
-	symbolTable := compiler.NewSymbolTable()
-	for i, v := range object.Builtins {
-		symbolTable.DefineBuiltin(i, v.Name)
+// session is the state kept between inputs
+type session struct {
+	out      io.Writer
+	eng      engine.Engine
+	macroEnv *object.Environment
+	timing   bool
+}
+
+// Start reads programs from in, runs them on eng, and writes their results
+// to out. Inputs starting with ':' are commands; see :help.
+func Start(in io.Reader, out io.Writer, eng engine.Engine) {
+	s := newSession(out, eng)
+	s.loop(&scannerReader{scanner: bufio.NewScanner(in), out: out})
+}
+
+func newSession(out io.Writer, eng engine.Engine) *session {
+	return &session{
+		out:      out,
+		eng:      eng,
+		macroEnv: object.NewEnvironment(),
 	}
+}
 
+func (s *session) loop(r lineReader) {
 	for {
-		fmt.Printf(PROMPT)
-		scanned := scanner.Scan()
-		if !scanned {
+		input, err := readInput(r)
+		if err == errInputAborted {
+			continue
+		}
+		if err != nil {
+			if err != io.EOF {
+				fmt.Fprintf(s.out, "%s\n", err)
+			}
 			return
 		}
 
-		line := scanner.Text()
-		l := lexer.New(line)
-		p := parser.New(l)
+		if strings.TrimSpace(input) == "" {
+			continue
+		}
+		r.addHistory(input)
 
-		program := p.ParseProgram()
-		if errs := p.Errors(); len(errs) != 0 {
-			printParserErrors(out, errs)
+		if strings.HasPrefix(strings.TrimSpace(input), ":") {
+			s.runCommand(strings.TrimSpace(input))
 			continue
 		}
 
-		evaluator.DefineMacros(program, macroEnv)
-		expanded := evaluator.ExpandMacros(program, macroEnv)
+		s.run("", input)
+	}
+}
+
+// run parses and runs source, read from the file name, and prints its result
+func (s *session) run(name, source string) {
+	program, ok := s.parse(name, source)
+	if !ok {
+		return
+	}
+
+	evaluator.DefineMacros(program, s.macroEnv)
+	expanded := evaluator.ExpandMacros(program, s.macroEnv)
+
+	start := time.Now()
+	result, err := s.eng.Run(expanded.(*ast.Program))
+	elapsed := time.Since(start)
+
+	if err != nil {
+		fmt.Fprintf(s.out, "%s\n", err)
+	} else if result != nil {
+		fmt.Fprintf(s.out, "%s\n", result.Inspect())
+	}
+
+	if s.timing {
+		fmt.Fprintf(s.out, "time: %s\n", elapsed)
+	}
+}
+
+// parse parses source, printing any errors
+func (s *session) parse(name, source string) (*ast.Program, bool) {
+	l := lexer.NewWithFilename(name, source)
+	p := parser.New(l)
+
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		printParserErrors(s.out, source, p.Diagnostics())
+		return nil, false
+	}
 
-		comp := compiler.NewWithState(symbolTable, constants)
-		err := comp.Compile(expanded)
+	return program, true
+}
+
+// readInput reads lines until they form a complete input
+func readInput(r lineReader) (string, error) {
+	input, err := r.readLine(PROMPT)
+	if err != nil {
+		return "", err
+	}
+
+	for !isComplete(input) {
+		line, err := r.readLine(CONTINUATION_PROMPT)
 		if err != nil {
-			fmt.Fprintf(out, "compilation failure:\n %s\n", err)
-			continue
+			return "", err
 		}
+		input += "\n" + line
+	}
 
-		code := comp.Bytecode()
-		constants = code.Constants
+	return input, nil
+}
 
-		machine := vm.NewWithGlobalsStore(code, globals)
-		err = machine.Run()
-		if err != nil {
-			fmt.Fprintf(out, "bytecode execution failure:\n %s\n", err)
-			continue
+// isComplete reports whether input is ready to be parsed. It is not if it
+// ends inside a string or with brackets, braces or parentheses left open.
+func isComplete(input string) bool {
+	l := lexer.New(input)
+	depth := 0
+
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		switch tok.Type {
+		case token.LPAREN, token.LBRACE, token.LBRACKET:
+			depth++
+		case token.RPAREN, token.RBRACE, token.RBRACKET:
+			depth--
+		case token.ILLEGAL:
+			src := input[tok.Pos.Offset:]
+			if strings.HasPrefix(src, `"`) && !hasClosingQuote(src) {
+				return false
+			}
 		}
+	}
+
+	return depth <= 0
+}
 
-		lastPopped := machine.LastPoppedStackElem()
-		fmt.Fprintf(out, "%s\n", lastPopped.Inspect())
+// hasClosingQuote reports whether the string literal at the start of src is
+// terminated
+func hasClosingQuote(src string) bool {
+	for i := 1; i < len(src); i++ {
+		switch src[i] {
+		case '\\':
+			i++
+		case '"':
+			return true
+		}
 	}
+	return false
 }
 
-func printParserErrors(out io.Writer, errors []string) {
+func printParserErrors(out io.Writer, source string, diagnostics []parser.Diagnostic) {
 	if _, err := io.WriteString(out, "parser errors:\n"); err != nil {
 		log.Fatalln(err)
 	}
-	for _, msg := range errors {
-		if _, err := io.WriteString(out, "\t"+msg+"\n"); err != nil {
+	for _, d := range diagnostics {
+		if _, err := io.WriteString(out, d.Format(source)+"\n"); err != nil {
 			log.Fatalln(err)
 		}
 	}
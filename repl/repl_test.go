@@ -0,0 +1,208 @@
+package repl
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/mikeraimondi/monkey/engine"
+)
+
+func TestIsComplete(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{``, true},
+		{`1 + 2`, true},
+		{`let f = fn(x) {`, false},
+		{"let f = fn(x) {\n  x\n}", true},
+		{`[1, [2, 3]`, false},
+		{`puts(1,`, false},
+		{`{"a": 1}`, true},
+		{`"unterminated`, false},
+		{"\"spans\nlines\"", true},
+		{`"bad \q escape"`, true},
+		{`1 }`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			if actual := isComplete(tt.input); actual != tt.expected {
+				t.Errorf("wrong result. expected %t, got %t", tt.expected, actual)
+			}
+		})
+	}
+}
+
+func TestStartMultiLine(t *testing.T) {
+	in := strings.NewReader("let add = fn(a, b) {\n  a + b\n};\nadd(1,\n 2)\n")
+	out := &bytes.Buffer{}
+
+	Start(in, out, engine.NewVM())
+
+	expected := ">> .. .. >> .. 3\n>> "
+	if out.String() != expected {
+		t.Errorf("wrong output.\nexpected %q\ngot %q", expected, out.String())
+	}
+}
+
+func TestFailedRuns(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			"let a = 1 / 0;\na + 1",
+			"execution failure: 1:11: division by zero\n" +
+				"execution failure: 1:1: undefined variable a\n",
+		},
+		{
+			"let b = 1; let c = nope;\nb == 1",
+			"compilation failure: 1:20: undefined variable nope\n" +
+				"compilation failure: 1:1: undefined variable b\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			Start(strings.NewReader(tt.input), out, engine.NewVM())
+
+			actual := strings.Replace(out.String(), PROMPT, "", -1)
+			if actual != tt.expected {
+				t.Errorf("wrong output.\nexpected %q\ngot %q", tt.expected, actual)
+			}
+		})
+	}
+}
+
+func TestCommands(t *testing.T) {
+	file, err := ioutil.TempFile("", "repl-*.mk")
+	if err != nil {
+		t.Fatalf("could not create file: %s", err)
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.WriteString("let sq = fn(x) { x * x };\nsq(3)"); err != nil {
+		t.Fatalf("could not write file: %s", err)
+	}
+	file.Close()
+
+	tests := []struct {
+		input    string
+		eng      engine.Engine
+		expected string
+	}{
+		{
+			"let b = 2;\nlet a = 1;\n:globals",
+			engine.NewVM(),
+			"a = 1\nb = 2\n",
+		},
+		{
+			"let b = 2;\nlet a = 1;\n:globals",
+			engine.NewEvaluator(),
+			"a = 1\nb = 2\n",
+		},
+		{
+			"\"s\";\n:constants",
+			engine.NewVM(),
+			"s\n0: STRING s\n",
+		},
+		{
+			":constants",
+			engine.NewEvaluator(),
+			"only available with the vm or diff engine\n",
+		},
+		{
+			"let a = 1;\n:disasm let b = a + 2\nb",
+			engine.NewVM(),
+			"main:\n" +
+				"  0000 OpGetGlobal 0            ; a\n" +
+				"  0003 OpAddConst 1             ; 2\n" +
+				"  0006 OpSetGlobal 1\n" +
+				"compilation failure: 1:1: undefined variable b\n",
+		},
+		{
+			":ast -x",
+			engine.NewVM(),
+			"Program @1:1\n" +
+				"  Statements[0]: ExpressionStatement @1:1\n" +
+				"    Expression: PrefixExpression Operator=\"-\" @1:1\n" +
+				"      Right: Identifier Value=\"x\" @1:2\n",
+		},
+		{
+			"let m = macro(x) { x };\n:macros",
+			engine.NewVM(),
+			"m = macro(x) {\nx\n}\n",
+		},
+		{
+			"let a = 1;\nlet m = macro(x) { x };\n:reset\n:globals\n:macros\nm(1)",
+			engine.NewVM(),
+			"compilation failure: 1:1: undefined variable m\n",
+		},
+		{
+			":load " + file.Name() + "\nsq(4)",
+			engine.NewVM(),
+			"9\n16\n",
+		},
+		{
+			":load",
+			engine.NewVM(),
+			"usage: :load <file>\n",
+		},
+		{
+			":nope",
+			engine.NewVM(),
+			"unknown command :nope. try :help\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			Start(strings.NewReader(tt.input), out, tt.eng)
+
+			actual := strings.Replace(out.String(), PROMPT, "", -1)
+			if actual != tt.expected {
+				t.Errorf("wrong output.\nexpected %q\ngot %q", tt.expected, actual)
+			}
+		})
+	}
+}
+
+func TestComplete(t *testing.T) {
+	s := newSession(&bytes.Buffer{}, engine.NewVM())
+	s.run("", "let counter = 1; let count = 2;")
+
+	tests := []struct {
+		line     string
+		pos      int
+		head     string
+		expected []string
+		tail     string
+	}{
+		{"le", 2, "", []string{"len", "let"}, ""},
+		{"puts(cou", 8, "puts(", []string{"count", "counter"}, ""},
+		{"puts(cou)", 8, "puts(", []string{"count", "counter"}, ")"},
+		{"fi", 2, "", []string{"first"}, ""},
+		{":gl", 3, ":", []string{"globals"}, ""},
+		{"xyz", 3, "", nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			head, completions, tail := s.complete(tt.line, tt.pos)
+			if head != tt.head {
+				t.Errorf("wrong head. expected %q, got %q", tt.head, head)
+			}
+			if strings.Join(completions, " ") != strings.Join(tt.expected, " ") {
+				t.Errorf("wrong completions. expected %q, got %q", tt.expected, completions)
+			}
+			if tail != tt.tail {
+				t.Errorf("wrong tail. expected %q, got %q", tt.tail, tail)
+			}
+		})
+	}
+}
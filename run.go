@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/mikeraimondi/monkey/ast"
+	"github.com/mikeraimondi/monkey/compiler"
+	"github.com/mikeraimondi/monkey/engine"
+	"github.com/mikeraimondi/monkey/evaluator"
+	"github.com/mikeraimondi/monkey/lexer"
+	"github.com/mikeraimondi/monkey/object"
+	"github.com/mikeraimondi/monkey/parser"
+	"github.com/mikeraimondi/monkey/vm"
+)
+
+// run executes the program in source, read from the file name, on eng with
+// args bound to the global "args". Errors are written to stderr. It returns
+// the exit code for the process.
+func run(eng engine.Engine, name, source string, args []string, stderr io.Writer) int {
+	program, ok := parse(name, source, stderr)
+	if !ok {
+		return exitFailure
+	}
+
+	eng.Define("args", newArgsArray(args))
+
+	result, err := eng.Run(program)
+	return report(result, err, stderr)
+}
+
+// runBytecode executes bytecode serialized by build on eng, with args bound
+// to the global "args"
+func runBytecode(eng engine.Engine, data []byte, args []string, stderr io.Writer) int {
+	machine, ok := eng.(*engine.VM)
+	if !ok {
+		fmt.Fprintln(stderr, "bytecode can only be run with the vm engine")
+		return exitUsage
+	}
+
+	code := &compiler.Bytecode{}
+	err := code.UnmarshalBinary(data)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitFailure
+	}
+
+	// build defines args first too, so the bytecode refers to the same global
+	machine.Define("args", newArgsArray(args))
+
+	result, err := machine.RunBytecode(code)
+	return report(result, err, stderr)
+}
+
+// parse parses source and expands its macros. Parse errors are written to
+// stderr.
+func parse(name, source string, stderr io.Writer) (*ast.Program, bool) {
+	l := lexer.NewWithFilename(name, source)
+	p := parser.New(l)
+
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		for _, d := range p.Diagnostics() {
+			fmt.Fprintln(stderr, d.Format(source))
+		}
+		return nil, false
+	}
+
+	macroEnv := object.NewEnvironment()
+	evaluator.DefineMacros(program, macroEnv)
+	expanded := evaluator.ExpandMacros(program, macroEnv)
+
+	return expanded.(*ast.Program), true
+}
+
+// report writes the error from running a program, if any, to stderr and
+// returns the exit code for the process
+func report(result object.Object, err error, stderr io.Writer) int {
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		// the trace is only worth showing when the error is in a function
+		var rtErr *vm.RuntimeError
+		if errors.As(err, &rtErr) && len(rtErr.Stack) > 1 {
+			fmt.Fprint(stderr, rtErr.StackTrace())
+		}
+		return exitFailure
+	}
+
+	// errors from builtins are values, so only one left as the program's
+	// result can be detected here
+	if errObj, ok := result.(*object.Error); ok {
+		fmt.Fprintf(stderr, "execution failure: %s\n", errObj.Message)
+		return exitFailure
+	}
+
+	return exitOK
+}
+
+func newArgsArray(args []string) *object.Array {
+	elements := make([]object.Object, len(args))
+	for i, arg := range args {
+		elements[i] = &object.String{Value: arg}
+	}
+	return &object.Array{Elements: elements}
+}
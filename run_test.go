@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/mikeraimondi/monkey/compiler"
+	"github.com/mikeraimondi/monkey/engine"
+)
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		input          string
+		args           []string
+		expectedCode   int
+		expectedStderr string
+	}{
+		{`let a = 1; a + 1;`, nil, exitOK, ""},
+		{`if (len(args) == 2) { len(last(args)) } else { len(1) }`, []string{"a", "bc"}, exitOK, ""},
+		{
+			`if (len(args) == 2) { 1 } else { len(1) }`,
+			[]string{"z"},
+			exitFailure,
+			"execution failure: argument to `len` not supported. got INTEGER\n",
+		},
+		{
+			`let = 1;`,
+			nil,
+			exitFailure,
+			"test.mk:1:5: error: expected next token to be IDENT, got = instead\nlet = 1;\n    ^\n",
+		},
+		{
+			`x;`,
+			nil,
+			exitFailure,
+			"compilation failure: test.mk:1:1: undefined variable x\n",
+		},
+		{
+			`return 5;`,
+			nil,
+			exitFailure,
+			"compilation failure: test.mk:1:1: return outside function\n",
+		},
+		{
+			`fn(a) { a }();`,
+			nil,
+			exitFailure,
+			"execution failure: test.mk:1:12: wrong number of arguments: expected 1, got 0\n",
+		},
+		{
+			"let div = fn(a, b) { a / b };\nlet half = fn(x) { 1 + div(x, 0) };\nhalf(4);",
+			nil,
+			exitFailure,
+			"execution failure: test.mk:1:24: division by zero\n" +
+				"  at fn div(a, b) (test.mk:1:24)\n" +
+				"  at fn half(x) (test.mk:2:27)\n" +
+				"  at <main> (test.mk:3:5)\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			stderr := &bytes.Buffer{}
+
+			code := run(engine.NewVM(), "test.mk", tt.input, tt.args, stderr)
+			if code != tt.expectedCode {
+				t.Errorf("wrong exit code. expected %d, got %d", tt.expectedCode, code)
+			}
+			if stderr.String() != tt.expectedStderr {
+				t.Errorf("wrong stderr.\nexpected %q\ngot %q", tt.expectedStderr, stderr.String())
+			}
+		})
+	}
+}
+
+func TestBuild(t *testing.T) {
+	tests := []struct {
+		input          string
+		args           []string
+		expectedCode   int
+		expectedStderr string
+	}{
+		{`if (len(args) == 2) { len(last(args)) } else { len(1) }`, []string{"a", "bc"}, exitOK, ""},
+		{
+			`let f = fn(x) { len(x) }; f(len(args))`,
+			nil,
+			exitFailure,
+			"execution failure: argument to `len` not supported. got INTEGER\n",
+		},
+		{
+			"let div = fn(a, b) { a / b };\ndiv(1, 0) + 1;",
+			nil,
+			exitFailure,
+			"execution failure: test.mk:1:24: division by zero\n" +
+				"  at fn div(a, b) (test.mk:1:24)\n" +
+				"  at <main> (test.mk:2:4)\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			// optimized bytecode must behave the same
+			for _, options := range [][]compiler.Option{nil, {compiler.WithOptimization()}} {
+				stderr := &bytes.Buffer{}
+
+				data, ok := build("test.mk", tt.input, stderr, options...)
+				if !ok {
+					t.Fatalf("build failed: %s", stderr)
+				}
+
+				code := runBytecode(engine.NewVM(), data, tt.args, stderr)
+				if code != tt.expectedCode {
+					t.Errorf("wrong exit code. expected %d, got %d", tt.expectedCode, code)
+				}
+				if stderr.String() != tt.expectedStderr {
+					t.Errorf("wrong stderr.\nexpected %q\ngot %q", tt.expectedStderr, stderr.String())
+				}
+			}
+		})
+	}
+}
+
+func TestBuildErrors(t *testing.T) {
+	stderr := &bytes.Buffer{}
+	if _, ok := build("test.mk", "x;", stderr); ok {
+		t.Fatalf("expected build to fail")
+	}
+	expected := "compilation failure: test.mk:1:1: undefined variable x\n"
+	if stderr.String() != expected {
+		t.Errorf("wrong stderr.\nexpected %q\ngot %q", expected, stderr.String())
+	}
+
+	stderr.Reset()
+	code := runBytecode(engine.NewEvaluator(), []byte("\x00mkc"), nil, stderr)
+	if code != exitUsage {
+		t.Errorf("wrong exit code. expected %d, got %d", exitUsage, code)
+	}
+}
+
+func TestDisasm(t *testing.T) {
+	data, ok := build("test.mk", "let n = len(args);", &bytes.Buffer{})
+	if !ok {
+		t.Fatalf("build failed")
+	}
+
+	listing := "main:\n" +
+		"  0000 OpGetBuiltin 0           ; len\n" +
+		"  0002 OpGetGlobal 0            ; args\n" +
+		"  0005 OpCall 1\n"
+
+	tests := []struct {
+		src      []byte
+		expected string
+	}{
+		// only source has the names of globals other than args
+		{[]byte("let n = len(args);"), listing + "  0007 OpSetGlobal 1            ; n\n"},
+		{data, listing + "  0007 OpSetGlobal 1\n"},
+	}
+
+	for _, tt := range tests {
+		out := &bytes.Buffer{}
+		if code := disasm("test.mk", tt.src, out, out); code != exitOK {
+			t.Fatalf("wrong exit code. expected %d, got %d: %s", exitOK, code, out)
+		}
+		if out.String() != tt.expected {
+			t.Errorf("wrong listing.\nexpected %q\ngot %q", tt.expected, out.String())
+		}
+	}
+}
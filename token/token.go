@@ -1,12 +1,45 @@
 package token
 
+import (
+	"fmt"
+	"sort"
+)
+
 // TokenType is a token represented by a string
 type TokenType string
 
-// Token contains its type and literal
+// Token contains its type, literal, and the span of source it was read from
 type Token struct {
 	Type    TokenType
 	Literal string
+	Pos     Position // position of the first character
+	End     Position // position immediately after the last character
+}
+
+// Position is a location in a source file
+type Position struct {
+	Filename string
+	Offset   int // byte offset, starting at 0
+	Line     int // line number, starting at 1
+	Column   int // byte column, starting at 1
+}
+
+// IsValid reports whether the position was set by a lexer
+func (p Position) IsValid() bool { return p.Line > 0 }
+
+// String returns the position as file:line:col, or line:col if there is no
+// file name. An invalid position is rendered as "-".
+func (p Position) String() string {
+	if !p.IsValid() {
+		if p.Filename != "" {
+			return p.Filename
+		}
+		return "-"
+	}
+	if p.Filename != "" {
+		return fmt.Sprintf("%s:%d:%d", p.Filename, p.Line, p.Column)
+	}
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
 }
 
 // enumerate token types
@@ -16,6 +49,7 @@ const (
 
 	IDENT  = "IDENT"
 	INT    = "INT"
+	FLOAT  = "FLOAT"
 	STRING = "STRING"
 
 	ASSIGN   = "="
@@ -24,12 +58,18 @@ const (
 	BANG     = "!"
 	ASTERISK = "*"
 	SLASH    = "/"
+	PERCENT  = "%"
 
 	LT     = "<"
 	GT     = ">"
+	LT_EQ  = "<="
+	GT_EQ  = ">="
 	EQ     = "=="
 	NOT_EQ = "!="
 
+	AND = "&&"
+	OR  = "||"
+
 	COMMA     = ","
 	SEMICOLON = ";"
 	COLON     = ":"
@@ -48,19 +88,39 @@ const (
 	IF       = "IF"
 	ELSE     = "ELSE"
 	RETURN   = "RETURN"
+	WHILE    = "WHILE"
+	FOR      = "FOR"
+	IN       = "IN"
+	BREAK    = "BREAK"
+	CONTINUE = "CONTINUE"
 
 	MACRO = "MACRO"
 )
 
 var keywords = map[string]TokenType{
-	"fn":     FUNCTION,
-	"let":    LET,
-	"true":   TRUE,
-	"false":  FALSE,
-	"if":     IF,
-	"else":   ELSE,
-	"return": RETURN,
-	"macro":  MACRO,
+	"fn":       FUNCTION,
+	"let":      LET,
+	"true":     TRUE,
+	"false":    FALSE,
+	"if":       IF,
+	"else":     ELSE,
+	"return":   RETURN,
+	"while":    WHILE,
+	"for":      FOR,
+	"in":       IN,
+	"break":    BREAK,
+	"continue": CONTINUE,
+	"macro":    MACRO,
+}
+
+// Keywords returns the words reserved by the language, sorted
+func Keywords() []string {
+	words := make([]string, 0, len(keywords))
+	for word := range keywords {
+		words = append(words, word)
+	}
+	sort.Strings(words)
+	return words
 }
 
 // LookupIdent returns either a known keyword or a user-defined identifier
@@ -0,0 +1,118 @@
+package vm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mikeraimondi/monkey/token"
+)
+
+// RuntimeError is an error raised while running bytecode, with the Monkey
+// call stack at the time. Functions that made a tail call are not on it, as
+// the callee took over their frame.
+type RuntimeError struct {
+	Err   error
+	Stack []StackFrame // innermost call first
+}
+
+// StackFrame is a function call that was running when an error was raised
+type StackFrame struct {
+	Function string         // signature of the function, e.g. fn fibonacci(x)
+	Offset   int            // offset of the instruction being executed
+	Pos      token.Position // position of that instruction, if known
+}
+
+func (f StackFrame) String() string {
+	return "at " + f.Function + " (" + f.Pos.String() + ")"
+}
+
+// Error returns the error prefixed with the position it was raised at, when
+// it is known
+func (e *RuntimeError) Error() string {
+	if len(e.Stack) == 0 || !e.Stack[0].Pos.IsValid() {
+		return e.Err.Error()
+	}
+	return e.Stack[0].Pos.String() + ": " + e.Err.Error()
+}
+
+func (e *RuntimeError) Unwrap() error { return e.Err }
+
+// UndefinedGlobalError is raised when a global is read before it is set, as
+// when the program defining it failed first. The VM doesn't know the names
+// of globals, so Name is left for those that do to fill in.
+type UndefinedGlobalError struct {
+	Index int
+	Name  string
+}
+
+func (e *UndefinedGlobalError) Error() string {
+	if e.Name == "" {
+		return "undefined variable"
+	}
+	return "undefined variable " + e.Name
+}
+
+// stackTraceLimit is the most calls a stack trace lists. The innermost and
+// outermost calls of deeper stacks are listed, and the rest counted.
+const stackTraceLimit = 20
+
+// stackRun is a frame repeated on the stack, as in recursion
+type stackRun struct {
+	frame StackFrame
+	count int
+}
+
+// StackTrace returns the call stack, one frame per line. Repeated frames are
+// listed once, followed by how many more there are.
+func (e *RuntimeError) StackTrace() string {
+	var runs []stackRun
+	for _, f := range e.Stack {
+		if n := len(runs); n > 0 && runs[n-1].frame == f {
+			runs[n-1].count++
+			continue
+		}
+		runs = append(runs, stackRun{frame: f, count: 1})
+	}
+
+	var out strings.Builder
+	if len(runs) <= stackTraceLimit {
+		writeStackRuns(&out, runs)
+		return out.String()
+	}
+
+	half := stackTraceLimit / 2
+	omitted := 0
+	for _, r := range runs[half : len(runs)-half] {
+		omitted += r.count
+	}
+	writeStackRuns(&out, runs[:half])
+	fmt.Fprintf(&out, "  ... %d more frames\n", omitted)
+	writeStackRuns(&out, runs[len(runs)-half:])
+	return out.String()
+}
+
+func writeStackRuns(out *strings.Builder, runs []stackRun) {
+	for _, r := range runs {
+		fmt.Fprintf(out, "  %s\n", r.frame)
+		if r.count > 1 {
+			fmt.Fprintf(out, "  ... %d more frames of %s\n", r.count-1, r.frame.Function)
+		}
+	}
+}
+
+// newRuntimeError returns err with the VM's call stack
+func (vm *VM) newRuntimeError(err error) *RuntimeError {
+	stack := make([]StackFrame, 0, vm.framesIndex)
+	for i := vm.framesIndex - 1; i >= 0; i-- {
+		frame := vm.frames[i]
+		f := StackFrame{Function: frame.cl.Inspect(), Offset: frame.ip}
+		if i == 0 {
+			f.Function = "<main>"
+		}
+		if pos, ok := frame.Position(); ok {
+			f.Pos = pos
+		}
+		stack = append(stack, f)
+	}
+	return &RuntimeError{Err: err, Stack: stack}
+}
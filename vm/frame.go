@@ -3,6 +3,7 @@ package vm
 import (
 	"github.com/mikeraimondi/monkey/code"
 	"github.com/mikeraimondi/monkey/object"
+	"github.com/mikeraimondi/monkey/token"
 )
 
 type Frame struct {
@@ -22,3 +23,8 @@ func NewFrame(cl *object.Closure, basePointer int) *Frame {
 func (f *Frame) Instructions() code.Instructions {
 	return f.cl.Fn.Instructions
 }
+
+// Position returns the source position of the instruction being executed
+func (f *Frame) Position() (token.Position, bool) {
+	return f.cl.Fn.SourceMap.Lookup(f.ip)
+}
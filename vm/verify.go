@@ -0,0 +1,295 @@
+package vm
+
+import (
+	"fmt"
+
+	"github.com/mikeraimondi/monkey/code"
+	"github.com/mikeraimondi/monkey/compiler"
+	"github.com/mikeraimondi/monkey/object"
+)
+
+// Verify checks that bytecode can be run without corrupting the VM: that
+// its instructions are well formed, that they refer to existing constants,
+// builtins, locals and free variables, and that every path through them
+// keeps the stack balanced. Bytecode from untrusted sources must be
+// verified before it is run.
+func Verify(bytecode *compiler.Bytecode) error {
+	v := &verifier{
+		constants: bytecode.Constants,
+		numFree:   map[*object.CompiledFunction]int{},
+	}
+
+	err := code.Verify(bytecode.Instructions)
+	if err != nil {
+		return err
+	}
+	for i, c := range v.constants {
+		if fn, ok := c.(*object.CompiledFunction); ok {
+			err := code.Verify(fn.Instructions)
+			if err != nil {
+				return fmt.Errorf("constant %d: %s", i, err)
+			}
+		}
+	}
+
+	// the number of free variables of a function is only known from the
+	// instructions creating its closures
+	err = v.collectFree(bytecode.Instructions)
+	if err != nil {
+		return err
+	}
+	for i, c := range v.constants {
+		if fn, ok := c.(*object.CompiledFunction); ok {
+			err := v.collectFree(fn.Instructions)
+			if err != nil {
+				return fmt.Errorf("constant %d: %s", i, err)
+			}
+		}
+	}
+
+	err = v.function(bytecode.Instructions, nil)
+	if err != nil {
+		return err
+	}
+	for i, c := range v.constants {
+		if fn, ok := c.(*object.CompiledFunction); ok {
+			err := v.function(fn.Instructions, fn)
+			if err != nil {
+				return fmt.Errorf("constant %d: %s", i, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+type verifier struct {
+	constants []object.Object
+	// numFree is the fewest free variables any closure of a function has
+	numFree map[*object.CompiledFunction]int
+}
+
+// collectFree checks the constants referred to by ins and records the
+// number of free variables of the closures it creates
+func (v *verifier) collectFree(ins code.Instructions) error {
+	return eachInstruction(ins, func(ip int, op code.Opcode, operands []int) error {
+		switch op {
+		case code.OpConstant, code.OpAddConst, code.OpSubConst:
+			if operands[0] >= len(v.constants) {
+				return fmt.Errorf("constant %d out of range at %d", operands[0], ip)
+			}
+		case code.OpIncrLocal, code.OpCompareConstJump:
+			if operands[1] >= len(v.constants) {
+				return fmt.Errorf("constant %d out of range at %d", operands[1], ip)
+			}
+		case code.OpClosure:
+			if operands[0] >= len(v.constants) {
+				return fmt.Errorf("constant %d out of range at %d", operands[0], ip)
+			}
+			fn, ok := v.constants[operands[0]].(*object.CompiledFunction)
+			if !ok {
+				return fmt.Errorf("constant %d is not a function at %d", operands[0], ip)
+			}
+			if n, ok := v.numFree[fn]; !ok || operands[1] < n {
+				v.numFree[fn] = operands[1]
+			}
+		}
+		return nil
+	})
+}
+
+// function checks the operands and stack use of the instructions of fn, or
+// of the main program if fn is nil
+func (v *verifier) function(ins code.Instructions, fn *object.CompiledFunction) error {
+	numLocals, numFree := 0, 0
+	if fn != nil {
+		if fn.NumParameters > fn.NumLocals {
+			return fmt.Errorf("%d parameters but only %d locals", fn.NumParameters, fn.NumLocals)
+		}
+		numLocals, numFree = fn.NumLocals, v.numFree[fn]
+	}
+
+	err := eachInstruction(ins, func(ip int, op code.Opcode, operands []int) error {
+		switch op {
+		case code.OpGetLocal, code.OpSetLocal, code.OpCaptureLocal, code.OpIncrLocal:
+			if operands[0] >= numLocals {
+				return fmt.Errorf("local %d out of range at %d", operands[0], ip)
+			}
+		case code.OpGetLocal0, code.OpGetLocal1, code.OpGetLocal2, code.OpGetLocal3:
+			if local := int(op - code.OpGetLocal0); local >= numLocals {
+				return fmt.Errorf("local %d out of range at %d", local, ip)
+			}
+		case code.OpGetFree, code.OpSetFree, code.OpCaptureFree:
+			if operands[0] >= numFree {
+				return fmt.Errorf("free variable %d out of range at %d", operands[0], ip)
+			}
+		case code.OpGetBuiltin:
+			if operands[0] >= len(object.Builtins) {
+				return fmt.Errorf("builtin %d out of range at %d", operands[0], ip)
+			}
+		case code.OpReturnValue, code.OpReturn:
+			if fn == nil {
+				return fmt.Errorf("return outside function at %d", ip)
+			}
+		case code.OpTailCall:
+			if fn == nil {
+				return fmt.Errorf("tail call outside function at %d", ip)
+			}
+		case code.OpCurrentClosure:
+			if fn == nil {
+				return fmt.Errorf("current closure outside function at %d", ip)
+			}
+		case code.OpHash:
+			if operands[0]%2 != 0 {
+				return fmt.Errorf("odd number of hash elements %d at %d", operands[0], ip)
+			}
+		case code.OpCompareJump, code.OpCompareConstJump:
+			comparison := operands[len(operands)-1]
+			switch code.Opcode(comparison) {
+			case code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpGreaterThanOrEqual:
+			default:
+				return fmt.Errorf("invalid comparison %d at %d", comparison, ip)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return verifyStack(ins, fn != nil)
+}
+
+// verifyStack follows every path through ins, checking that no instruction
+// pops more than is on the stack and that paths meet with the same stack
+// depth. Functions must return rather than run past their last instruction.
+func verifyStack(ins code.Instructions, isFunction bool) error {
+	depths := make([]int, len(ins)+1)
+	for i := range depths {
+		depths[i] = -1
+	}
+
+	var work []int
+	reach := func(ip, depth int) error {
+		if depths[ip] == -1 {
+			depths[ip] = depth
+			work = append(work, ip)
+		} else if depths[ip] != depth {
+			return fmt.Errorf("stack depth at %d is either %d or %d", ip, depths[ip], depth)
+		}
+		return nil
+	}
+
+	err := reach(0, 0)
+	for err == nil && len(work) > 0 {
+		ip := work[len(work)-1]
+		work = work[:len(work)-1]
+		depth := depths[ip]
+
+		if ip == len(ins) {
+			if isFunction {
+				return fmt.Errorf("function does not return")
+			}
+			continue
+		}
+
+		def, _ := code.Lookup(ins[ip])
+		operands, read := code.ReadOperands(def, ins[ip+1:])
+		op := code.Opcode(ins[ip])
+		next := ip + 1 + read
+
+		pops, pushes, effectErr := stackEffect(op, operands)
+		if effectErr != nil {
+			return fmt.Errorf("%s at %d", effectErr, ip)
+		}
+		if depth < pops {
+			return fmt.Errorf("stack underflow at %d", ip)
+		}
+		after := depth - pops + pushes
+
+		switch op {
+		case code.OpJump:
+			err = reach(operands[0], after)
+		case code.OpJumpNotTruthy, code.OpCompareJump, code.OpCompareConstJump:
+			err = reach(operands[0], after)
+			if err == nil {
+				err = reach(next, after)
+			}
+		case code.OpJumpNotTruthyOrPop, code.OpJumpTruthyOrPop:
+			// the condition stays on the stack if they jump
+			err = reach(operands[0], after+1)
+			if err == nil {
+				err = reach(next, after)
+			}
+		case code.OpIterNext:
+			// the array and index are popped when the loop ends
+			err = reach(operands[0], depth-2)
+			if err == nil {
+				err = reach(next, after)
+			}
+		case code.OpReturnValue, code.OpReturn:
+		default:
+			err = reach(next, after)
+		}
+	}
+
+	return err
+}
+
+// stackEffect returns the number of values an instruction pops off the
+// stack and the number it pushes
+func stackEffect(op code.Opcode, operands []int) (int, int, error) {
+	switch op {
+	case code.OpConstant, code.OpTrue, code.OpFalse, code.OpNull,
+		code.OpGetGlobal, code.OpGetLocal, code.OpGetBuiltin, code.OpGetFree,
+		code.OpCaptureLocal, code.OpCaptureFree, code.OpCurrentClosure,
+		code.OpGetLocal0, code.OpGetLocal1, code.OpGetLocal2, code.OpGetLocal3:
+		return 0, 1, nil
+	case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod,
+		code.OpEqual, code.OpNotEqual, code.OpGreaterThan,
+		code.OpGreaterThanOrEqual, code.OpIndex:
+		return 2, 1, nil
+	case code.OpMinus, code.OpBang, code.OpAddConst, code.OpSubConst:
+		return 1, 1, nil
+	case code.OpPop, code.OpSetGlobal, code.OpSetLocal, code.OpSetFree,
+		code.OpJumpNotTruthy, code.OpJumpNotTruthyOrPop,
+		code.OpJumpTruthyOrPop, code.OpReturnValue, code.OpCompareConstJump:
+		return 1, 0, nil
+	case code.OpCompareJump:
+		return 2, 0, nil
+	case code.OpJump, code.OpReturn, code.OpIncrLocal:
+		return 0, 0, nil
+	case code.OpIterInit:
+		// the array is left under the index
+		return 1, 2, nil
+	case code.OpIterNext:
+		// the array and index are left under the element
+		return 2, 3, nil
+	case code.OpSetIndex:
+		return 3, 1, nil
+	case code.OpArray, code.OpHash:
+		return operands[0], 1, nil
+	case code.OpCall, code.OpTailCall:
+		return operands[0] + 1, 1, nil
+	case code.OpClosure:
+		return operands[1], 1, nil
+	default:
+		def, _ := code.Lookup(byte(op))
+		return 0, 0, fmt.Errorf("unverifiable opcode %s", def.Name)
+	}
+}
+
+// eachInstruction calls f with each instruction of ins, which must have
+// passed code.Verify, until f returns an error
+func eachInstruction(ins code.Instructions, f func(ip int, op code.Opcode, operands []int) error) error {
+	for ip := 0; ip < len(ins); {
+		def, _ := code.Lookup(ins[ip])
+		operands, read := code.ReadOperands(def, ins[ip+1:])
+		err := f(ip, code.Opcode(ins[ip]), operands)
+		if err != nil {
+			return err
+		}
+		ip += 1 + read
+	}
+	return nil
+}
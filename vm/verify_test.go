@@ -0,0 +1,235 @@
+package vm
+
+import (
+	"testing"
+
+	"github.com/mikeraimondi/monkey/code"
+	"github.com/mikeraimondi/monkey/compiler"
+	"github.com/mikeraimondi/monkey/object"
+)
+
+func TestVerify(t *testing.T) {
+	fn := func(numLocals int, ins ...[]byte) *object.CompiledFunction {
+		return &object.CompiledFunction{Instructions: concat(ins), NumLocals: numLocals}
+	}
+
+	tests := []struct {
+		name      string
+		ins       [][]byte
+		constants []object.Object
+		expected  string
+	}{
+		{
+			name:     "unknown opcode",
+			ins:      [][]byte{{255}},
+			expected: "opcode 255 undefinied at 0",
+		},
+		{
+			name:     "constant out of range",
+			ins:      [][]byte{code.Make(code.OpConstant, 0), code.Make(code.OpPop)},
+			expected: "constant 0 out of range at 0",
+		},
+		{
+			name:      "closure of non-function",
+			ins:       [][]byte{code.Make(code.OpClosure, 0, 0), code.Make(code.OpPop)},
+			constants: []object.Object{&object.Integer{Value: 1}},
+			expected:  "constant 0 is not a function at 0",
+		},
+		{
+			name:     "builtin out of range",
+			ins:      [][]byte{code.Make(code.OpGetBuiltin, 200), code.Make(code.OpPop)},
+			expected: "builtin 200 out of range at 0",
+		},
+		{
+			name:     "local in main program",
+			ins:      [][]byte{code.Make(code.OpGetLocal, 0), code.Make(code.OpPop)},
+			expected: "local 0 out of range at 0",
+		},
+		{
+			name:     "return outside function",
+			ins:      [][]byte{code.Make(code.OpTrue), code.Make(code.OpReturnValue)},
+			expected: "return outside function at 1",
+		},
+		{
+			name:     "stack underflow",
+			ins:      [][]byte{code.Make(code.OpTrue), code.Make(code.OpAdd)},
+			expected: "stack underflow at 1",
+		},
+		{
+			name:     "iteration over empty stack",
+			ins:      [][]byte{code.Make(code.OpIterInit), code.Make(code.OpPop)},
+			expected: "stack underflow at 0",
+		},
+		{
+			name: "unbalanced branches",
+			ins: [][]byte{
+				code.Make(code.OpTrue),
+				code.Make(code.OpJumpNotTruthy, 5),
+				code.Make(code.OpTrue),
+				code.Make(code.OpPop),
+			},
+			expected: "stack depth at 5 is either 0 or 1",
+		},
+		{
+			name: "free variable out of range",
+			ins:  [][]byte{code.Make(code.OpClosure, 0, 0), code.Make(code.OpPop)},
+			constants: []object.Object{
+				fn(0, code.Make(code.OpGetFree, 0), code.Make(code.OpReturnValue)),
+			},
+			expected: "constant 0: free variable 0 out of range at 0",
+		},
+		{
+			name: "local out of range",
+			ins:  [][]byte{code.Make(code.OpClosure, 0, 0), code.Make(code.OpPop)},
+			constants: []object.Object{
+				fn(1, code.Make(code.OpGetLocal, 1), code.Make(code.OpReturnValue)),
+			},
+			expected: "constant 0: local 1 out of range at 0",
+		},
+		{
+			name: "specialized local out of range",
+			ins:  [][]byte{code.Make(code.OpClosure, 0, 0), code.Make(code.OpPop)},
+			constants: []object.Object{
+				fn(3, code.Make(code.OpGetLocal3), code.Make(code.OpReturnValue)),
+			},
+			expected: "constant 0: local 3 out of range at 0",
+		},
+		{
+			name: "invalid comparison",
+			ins: [][]byte{
+				code.Make(code.OpTrue),
+				code.Make(code.OpTrue),
+				code.Make(code.OpCompareJump, 6, int(code.OpAdd)),
+			},
+			expected: "invalid comparison 1 at 2",
+		},
+		{
+			name: "comparison underflow",
+			ins: [][]byte{
+				code.Make(code.OpTrue),
+				code.Make(code.OpCompareJump, 5, int(code.OpEqual)),
+			},
+			expected: "stack underflow at 1",
+		},
+		{
+			name:      "incremented constant out of range",
+			ins:       [][]byte{code.Make(code.OpClosure, 0, 0), code.Make(code.OpPop)},
+			constants: []object.Object{fn(1, code.Make(code.OpIncrLocal, 0, 1), code.Make(code.OpReturn))},
+			expected:  "constant 0: constant 1 out of range at 0",
+		},
+		{
+			name: "compared constant out of range",
+			ins:  [][]byte{code.Make(code.OpClosure, 0, 0), code.Make(code.OpPop)},
+			constants: []object.Object{fn(1,
+				code.Make(code.OpGetLocal0),
+				code.Make(code.OpCompareConstJump, 7, 1, int(code.OpEqual)),
+				code.Make(code.OpReturn),
+			)},
+			expected: "constant 0: constant 1 out of range at 1",
+		},
+		{
+			name: "function without return",
+			ins:  [][]byte{code.Make(code.OpClosure, 0, 0), code.Make(code.OpPop)},
+			constants: []object.Object{
+				fn(0, code.Make(code.OpTrue), code.Make(code.OpPop)),
+			},
+			expected: "constant 0: function does not return",
+		},
+		{
+			name: "valid closure",
+			ins: [][]byte{
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpClosure, 0, 1),
+				code.Make(code.OpPop),
+			},
+			constants: []object.Object{
+				fn(1, code.Make(code.OpGetFree, 0), code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpAdd), code.Make(code.OpReturnValue)),
+				&object.Integer{Value: 1},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Verify(&compiler.Bytecode{Instructions: concat(tt.ins), Constants: tt.constants})
+			if tt.expected == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %s", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.expected {
+				t.Errorf("wrong error. expected %q, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+// TestRunVerified checks that verified bytecode the compiler would not
+// produce fails at run time rather than crashing the VM
+func TestRunVerified(t *testing.T) {
+	tests := []struct {
+		name      string
+		ins       [][]byte
+		constants []object.Object
+		expected  string
+	}{
+		{
+			name:     "unset global",
+			ins:      [][]byte{code.Make(code.OpGetGlobal, 0), code.Make(code.OpPop)},
+			expected: "undefined variable",
+		},
+		{
+			name: "unset local",
+			ins: [][]byte{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpCall, 0),
+				code.Make(code.OpMinus),
+				code.Make(code.OpPop),
+			},
+			constants: []object.Object{&object.CompiledFunction{
+				Instructions: concat([][]byte{code.Make(code.OpGetLocal, 0), code.Make(code.OpReturnValue)}),
+				NumLocals:    1,
+			}},
+			expected: "unsupported type for negation: NULL",
+		},
+		{
+			name: "negative loop index",
+			ins: [][]byte{
+				code.Make(code.OpArray, 0),
+				code.Make(code.OpIterInit),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpIterNext, 14),
+				code.Make(code.OpPop),
+				code.Make(code.OpPop),
+				code.Make(code.OpPop),
+			},
+			constants: []object.Object{&object.Integer{Value: -1}},
+			expected:  "invalid loop index: -1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bytecode := &compiler.Bytecode{Instructions: concat(tt.ins), Constants: tt.constants}
+			err := Verify(bytecode)
+			if err != nil {
+				t.Fatalf("bytecode not verified: %s", err)
+			}
+			err = New(bytecode).Run()
+			if err == nil || err.Error() != tt.expected {
+				t.Errorf("wrong error. expected %q, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func concat(ins [][]byte) code.Instructions {
+	out := code.Instructions{}
+	for _, i := range ins {
+		out = append(out, i...)
+	}
+	return out
+}
@@ -2,6 +2,7 @@ package vm
 
 import (
 	"fmt"
+	"math"
 
 	"github.com/mikeraimondi/monkey/code"
 	"github.com/mikeraimondi/monkey/compiler"
@@ -9,9 +10,15 @@ import (
 )
 
 const (
-	StackSize   = 2048
-	GlobalsSize = 65536
-	MaxFrames   = 1024
+	// DefaultStackSize and DefaultMaxFrames are the limits of a VM's stack
+	// and call depth unless given as options
+	DefaultStackSize = 1 << 20
+	DefaultMaxFrames = 1 << 16
+	GlobalsSize      = 65536
+
+	// the stack and frame stack start small and grow as needed
+	initialStackSize = 256
+	initialFrames    = 16
 )
 
 var (
@@ -20,6 +27,26 @@ var (
 	Null  = &object.Null{}
 )
 
+// smallIntegers holds the integers from minSmallInteger up to 1023, which
+// results of arithmetic share rather than allocating their own. Integers are
+// never modified, so sharing them is safe.
+const minSmallInteger = -128
+
+var smallIntegers = func() []*object.Integer {
+	integers := make([]*object.Integer, 1024-minSmallInteger)
+	for i := range integers {
+		integers[i] = &object.Integer{Value: int64(i + minSmallInteger)}
+	}
+	return integers
+}()
+
+func newInteger(value int64) *object.Integer {
+	if i := value - minSmallInteger; i >= 0 && i < int64(len(smallIntegers)) {
+		return smallIntegers[i]
+	}
+	return &object.Integer{Value: value}
+}
+
 type VM struct {
 	constants []object.Object
 	globals   []object.Object
@@ -29,32 +56,107 @@ type VM struct {
 
 	frames      []*Frame
 	framesIndex int
+
+	maxStackSize int
+	maxFrames    int
+
+	// openCells are the cells still referring to stack slots, in the order
+	// of their frames
+	openCells []openCell
+}
+
+// openCell is a cell for the variable in a stack slot of a running function
+type openCell struct {
+	slot int
+	cell *object.Cell
 }
 
-func New(bytecode *compiler.Bytecode) *VM {
-	mainFn := &object.CompiledFunction{Instructions: bytecode.Instructions}
+// Option configures a VM
+type Option func(*VM)
+
+// WithStackSize limits the number of values on the stack
+func WithStackSize(n int) Option {
+	return func(vm *VM) { vm.maxStackSize = n }
+}
+
+// WithMaxFrames limits the depth of nested function calls
+func WithMaxFrames(n int) Option {
+	return func(vm *VM) { vm.maxFrames = n }
+}
+
+// WithGlobals makes the VM store its globals in s, so that they outlive it
+func WithGlobals(s []object.Object) Option {
+	return func(vm *VM) { vm.globals = s }
+}
+
+func New(bytecode *compiler.Bytecode, options ...Option) *VM {
+	mainFn := &object.CompiledFunction{
+		Instructions: bytecode.Instructions,
+		SourceMap:    bytecode.SourceMap,
+	}
 	mainClosure := &object.Closure{Fn: mainFn}
 	mainFrame := NewFrame(mainClosure, 0)
 
-	frames := make([]*Frame, MaxFrames)
+	frames := make([]*Frame, 1, initialFrames)
 	frames[0] = mainFrame
 
-	return &VM{
+	vm := &VM{
 		constants: bytecode.Constants,
-		globals:   make([]object.Object, GlobalsSize),
 
-		stack: make([]object.Object, StackSize),
-		sp:    0,
+		sp: 0,
 
 		frames:      frames,
 		framesIndex: 1,
+
+		maxStackSize: DefaultStackSize,
+		maxFrames:    DefaultMaxFrames,
+	}
+	for _, option := range options {
+		option(vm)
+	}
+
+	if vm.globals == nil {
+		vm.globals = make([]object.Object, numGlobals(bytecode))
+	}
+	size := initialStackSize
+	if size > vm.maxStackSize {
+		size = vm.maxStackSize
+	}
+	vm.stack = make([]object.Object, size)
+
+	return vm
+}
+
+// numGlobals returns the number of globals a VM needs to run bytecode,
+// which is GlobalsSize if its instructions are malformed
+func numGlobals(bytecode *compiler.Bytecode) int {
+	n := 0
+	count := func(ins code.Instructions) error {
+		err := code.Verify(ins)
+		if err != nil {
+			return err
+		}
+		return eachInstruction(ins, func(ip int, op code.Opcode, operands []int) error {
+			if (op == code.OpGetGlobal || op == code.OpSetGlobal) && operands[0] >= n {
+				n = operands[0] + 1
+			}
+			return nil
+		})
+	}
+
+	if count(bytecode.Instructions) != nil {
+		return GlobalsSize
 	}
+	for _, c := range bytecode.Constants {
+		if fn, ok := c.(*object.CompiledFunction); ok && count(fn.Instructions) != nil {
+			return GlobalsSize
+		}
+	}
+	return n
 }
 
 func NewWithGlobalsStore(bytecode *compiler.Bytecode, s []object.Object) *VM {
-	vm := New(bytecode)
-	vm.globals = s
-	return vm
+	return New(bytecode, WithGlobals(s))
 }
 
 func (vm *VM) StackTop() object.Object {
@@ -68,9 +170,23 @@ func (vm *VM) currentFrame() *Frame {
 	return vm.frames[vm.framesIndex-1]
 }
 
-func (vm *VM) pushFrame(f *Frame) {
-	vm.frames[vm.framesIndex] = f
+// pushFrame enters a frame executing cl, reusing the Frame of a call that
+// has returned if there is one
+func (vm *VM) pushFrame(cl *object.Closure, basePointer int) error {
+	if vm.framesIndex >= vm.maxFrames {
+		return fmt.Errorf("maximum recursion depth exceeded")
+	}
+
+	if vm.framesIndex == len(vm.frames) {
+		vm.frames = append(vm.frames, NewFrame(cl, basePointer))
+	} else {
+		f := vm.frames[vm.framesIndex]
+		f.cl = cl
+		f.ip = -1
+		f.basePointer = basePointer
+	}
 	vm.framesIndex++
+	return nil
 }
 
 func (vm *VM) popFrame() *Frame {
@@ -78,184 +194,253 @@ func (vm *VM) popFrame() *Frame {
 	return vm.frames[vm.framesIndex]
 }
 
+// Run executes the bytecode. Errors are *RuntimeErrors, carrying the call
+// stack at the instruction that caused them.
 func (vm *VM) Run() error {
-	var ip int
-	var ins code.Instructions
-	var op code.Opcode
+	err := vm.run()
+	if err == nil {
+		return nil
+	}
+	return vm.newRuntimeError(err)
+}
 
-	for vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
-		vm.currentFrame().ip++
+func (vm *VM) run() error {
+	// the current frame and its instructions and instruction pointer are
+	// kept in locals. ip is written back to the frame before anything that
+	// may read it or switch frames, and read again afterwards.
+	frame := vm.currentFrame()
+	ins := frame.Instructions()
+	ip := frame.ip
 
-		ip = vm.currentFrame().ip
-		ins = vm.currentFrame().Instructions()
-		op = code.Opcode(ins[ip])
+	var err error
+	for ip < len(ins)-1 {
+		ip++
+		op := code.Opcode(ins[ip])
 
 		switch op {
 		case code.OpConstant:
 			constIndex := code.ReadUint16(ins[ip+1:])
-			vm.currentFrame().ip += 2
-			err := vm.push(vm.constants[constIndex])
-			if err != nil {
-				return err
-			}
+			ip += 2
+			err = vm.push(vm.constants[constIndex])
 		case code.OpSetGlobal:
 			globalIndex := code.ReadUint16(ins[ip+1:])
-			vm.currentFrame().ip += 2
+			ip += 2
 			vm.globals[globalIndex] = vm.pop()
 		case code.OpGetGlobal:
 			globalIndex := code.ReadUint16(ins[ip+1:])
-			vm.currentFrame().ip += 2
-			err := vm.push(vm.globals[globalIndex])
-			if err != nil {
-				return err
+			ip += 2
+			// a global is unset if the program defining it failed first
+			if global := vm.globals[globalIndex]; global != nil {
+				err = vm.push(global)
+			} else {
+				err = &UndefinedGlobalError{Index: int(globalIndex)}
 			}
 		case code.OpSetLocal:
 			localIndex := code.ReadUint8(ins[ip+1:])
-			vm.currentFrame().ip += 1
-			frame := vm.currentFrame()
+			ip += 1
 			vm.stack[frame.basePointer+int(localIndex)] = vm.pop()
 		case code.OpGetLocal:
 			localIndex := code.ReadUint8(ins[ip+1:])
-			vm.currentFrame().ip += 1
-			frame := vm.currentFrame()
-			err := vm.push(vm.stack[frame.basePointer+int(localIndex)])
-			if err != nil {
-				return err
-			}
+			ip += 1
+			err = vm.push(vm.stack[frame.basePointer+int(localIndex)])
+		case code.OpGetLocal0, code.OpGetLocal1, code.OpGetLocal2, code.OpGetLocal3:
+			err = vm.push(vm.stack[frame.basePointer+int(op-code.OpGetLocal0)])
+		case code.OpIncrLocal:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			constIndex := code.ReadUint16(ins[ip+2:])
+			ip += 3
+			err = vm.executeIncrLocal(frame.basePointer+int(localIndex), vm.constants[constIndex])
 		case code.OpGetBuiltin:
 			builtinIndex := code.ReadUint8(ins[ip+1:])
-			vm.currentFrame().ip += 1
+			ip += 1
 			definition := object.Builtins[builtinIndex]
-			err := vm.push(definition.Builtin)
-			if err != nil {
-				return err
-			}
+			err = vm.push(definition.Builtin)
 		case code.OpGetFree:
 			freeIndex := code.ReadUint8(ins[ip+1:])
-			vm.currentFrame().ip += 1
-			currentClosure := vm.currentFrame().cl
-			err := vm.push(currentClosure.Free[freeIndex])
-			if err != nil {
-				return err
-			}
+			ip += 1
+			err = vm.push(*frame.cl.Free[freeIndex].Ref)
+		case code.OpSetFree:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			ip += 1
+			*frame.cl.Free[freeIndex].Ref = vm.pop()
+		case code.OpCaptureLocal:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			ip += 1
+			err = vm.push(vm.captureLocal(frame.basePointer + int(localIndex)))
+		case code.OpCaptureFree:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			ip += 1
+			err = vm.push(frame.cl.Free[freeIndex])
+		case code.OpCurrentClosure:
+			err = vm.push(frame.cl)
 		case code.OpTrue:
-			err := vm.push(True)
-			if err != nil {
-				return err
-			}
+			err = vm.push(True)
 		case code.OpFalse:
-			err := vm.push(False)
-			if err != nil {
-				return err
-			}
-		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv:
-			err := vm.executeBinaryOperation(op)
-			if err != nil {
-				return err
+			err = vm.push(False)
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod:
+			err = vm.executeBinaryOperation(op)
+		case code.OpAddConst:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			ip += 2
+			err = vm.executeConstOperation(code.OpAdd, vm.constants[constIndex])
+		case code.OpSubConst:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			ip += 2
+			err = vm.executeConstOperation(code.OpSub, vm.constants[constIndex])
+		case code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpGreaterThanOrEqual:
+			err = vm.executeComparison(op)
+		case code.OpCompareJump:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			comparison := code.Opcode(ins[ip+3])
+			ip += 3
+			var ok bool
+			ok, err = vm.compare(comparison)
+			if err == nil && !ok {
+				ip = pos - 1
 			}
-		case code.OpEqual, code.OpNotEqual, code.OpGreaterThan:
-			err := vm.executeComparison(op)
-			if err != nil {
-				return err
+		case code.OpCompareConstJump:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			constIndex := code.ReadUint16(ins[ip+3:])
+			comparison := code.Opcode(ins[ip+5])
+			ip += 5
+			var ok bool
+			ok, err = vm.compareConst(comparison, vm.constants[constIndex])
+			if err == nil && !ok {
+				ip = pos - 1
 			}
 		case code.OpBang:
-			err := vm.executeBangOperator()
-			if err != nil {
-				return err
-			}
+			err = vm.executeBangOperator()
 		case code.OpMinus:
-			err := vm.executeMinusOperator()
-			if err != nil {
-				return err
-			}
+			err = vm.executeMinusOperator()
 		case code.OpClosure:
 			constIndex := code.ReadUint16(ins[ip+1:])
 			numFree := code.ReadUint8(ins[ip+3:])
-			vm.currentFrame().ip += 3
-			err := vm.pushClosure(int(constIndex), int(numFree))
-			if err != nil {
-				return err
-			}
-		case code.OpCall:
+			ip += 3
+			err = vm.pushClosure(int(constIndex), int(numFree))
+		case code.OpCall, code.OpTailCall:
 			numArgs := code.ReadUint8(ins[ip+1:])
-			vm.currentFrame().ip += 1
-			err := vm.executeCall(int(numArgs))
-			if err != nil {
-				return err
+			ip += 1
+			frame.ip = ip
+			if op == code.OpCall {
+				err = vm.executeCall(int(numArgs))
+			} else {
+				err = vm.executeTailCall(int(numArgs))
 			}
-		case code.OpReturnValue:
-			returnValue := vm.pop()
-			frame := vm.popFrame()
-			vm.sp = frame.basePointer - 1
-			err := vm.push(returnValue)
 			if err != nil {
 				return err
 			}
-		case code.OpReturn:
-			frame := vm.popFrame()
-			vm.sp = frame.basePointer - 1
-			err := vm.push(Null)
-			if err != nil {
-				return err
+			frame = vm.currentFrame()
+			ins = frame.Instructions()
+			ip = frame.ip
+		case code.OpReturnValue, code.OpReturn:
+			var returnValue object.Object = Null
+			if op == code.OpReturnValue {
+				returnValue = vm.pop()
 			}
+			vm.popFrame()
+			vm.closeCells(frame.basePointer)
+			vm.sp = frame.basePointer - 1
+			err = vm.push(returnValue)
+
+			frame = vm.currentFrame()
+			ins = frame.Instructions()
+			ip = frame.ip
 		case code.OpIndex:
 			index := vm.pop()
 			left := vm.pop()
-			err := vm.executeIndexExpression(left, index)
-			if err != nil {
-				return err
-			}
+			err = vm.executeIndexExpression(left, index)
+		case code.OpSetIndex:
+			value := vm.pop()
+			index := vm.pop()
+			left := vm.pop()
+			err = vm.executeSetIndex(left, index, value)
 		case code.OpArray:
 			numElements := int(code.ReadUint16(ins[ip+1:]))
-			vm.currentFrame().ip += 2
+			ip += 2
 			array := vm.buildArray(vm.sp-numElements, vm.sp)
 			vm.sp = vm.sp - numElements
-			err := vm.push(array)
-			if err != nil {
-				return err
-			}
+			err = vm.push(array)
 		case code.OpHash:
 			numElements := int(code.ReadUint16(ins[ip+1:]))
-			vm.currentFrame().ip += 2
-			hash, err := vm.buildHash(vm.sp-numElements, vm.sp)
-			if err != nil {
-				return err
-			}
-			vm.sp = vm.sp - numElements
-			err = vm.push(hash)
-			if err != nil {
-				return err
+			ip += 2
+			var hash object.Object
+			hash, err = vm.buildHash(vm.sp-numElements, vm.sp)
+			if err == nil {
+				vm.sp = vm.sp - numElements
+				err = vm.push(hash)
 			}
 		case code.OpPop:
 			vm.pop()
 		case code.OpJumpNotTruthy:
 			pos := int(code.ReadUint16(ins[ip+1:]))
-			vm.currentFrame().ip += 2
+			ip += 2
 			condition := vm.pop()
 			if !isTruthy(condition) {
-				vm.currentFrame().ip = pos - 1
+				ip = pos - 1
 			}
 		case code.OpJump:
 			pos := int(code.ReadUint16(ins[ip+1:]))
-			vm.currentFrame().ip = pos - 1
+			ip = pos - 1
+		case code.OpJumpNotTruthyOrPop, code.OpJumpTruthyOrPop:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			ip += 2
+			if isTruthy(vm.StackTop()) == (op == code.OpJumpTruthyOrPop) {
+				ip = pos - 1
+			} else {
+				vm.pop()
+			}
 		case code.OpNull:
-			err := vm.push(Null)
-			if err != nil {
-				return err
+			err = vm.push(Null)
+		case code.OpIterInit:
+			switch iterable := vm.StackTop().(type) {
+			case *object.Array:
+				err = vm.push(newInteger(0))
+			case nil:
+				err = fmt.Errorf("nothing to iterate over")
+			default:
+				err = fmt.Errorf("cannot iterate over %s", iterable.Type())
+			}
+		case code.OpIterNext:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			ip += 2
+			var done bool
+			done, err = vm.executeIterNext()
+			if done {
+				ip = pos - 1
 			}
 		}
+
+		if err != nil {
+			frame.ip = ip
+			return err
+		}
 	}
 
+	frame.ip = ip
 	return nil
 }
 
 func (vm *VM) LastPoppedStackElem() object.Object {
+	if vm.sp >= len(vm.stack) {
+		return nil
+	}
 	return vm.stack[vm.sp]
 }
 
 func (vm *VM) push(o object.Object) error {
-	if vm.sp >= StackSize {
-		return fmt.Errorf("stack overflow")
+	// growing is kept out of line so that push can be inlined
+	if sp := vm.sp; sp < len(vm.stack) {
+		vm.stack[sp] = o
+		vm.sp = sp + 1
+		return nil
+	}
+	return vm.growAndPush(o)
+}
+
+func (vm *VM) growAndPush(o object.Object) error {
+	err := vm.growStack(vm.sp + 1)
+	if err != nil {
+		return err
 	}
 
 	vm.stack[vm.sp] = o
@@ -264,6 +449,34 @@ func (vm *VM) push(o object.Object) error {
 	return nil
 }
 
+// growStack makes room for at least n values on the stack
+func (vm *VM) growStack(n int) error {
+	if n <= len(vm.stack) {
+		return nil
+	}
+	if n > vm.maxStackSize {
+		return fmt.Errorf("stack overflow")
+	}
+
+	size := 2 * len(vm.stack)
+	if size < n {
+		size = n
+	}
+	if size > vm.maxStackSize {
+		size = vm.maxStackSize
+	}
+	stack := make([]object.Object, size)
+	copy(stack, vm.stack)
+	vm.stack = stack
+
+	// open cells refer to the slots of the old stack
+	for _, open := range vm.openCells {
+		open.cell.Ref = &vm.stack[open.slot]
+	}
+
+	return nil
+}
+
 func (vm *VM) pop() object.Object {
 	o := vm.stack[vm.sp-1]
 	vm.sp--
@@ -274,12 +487,20 @@ func (vm *VM) executeBinaryOperation(op code.Opcode) error {
 	right := vm.pop()
 	left := vm.pop()
 
+	leftInt, leftOk := left.(*object.Integer)
+	rightInt, rightOk := right.(*object.Integer)
+	if leftOk && rightOk {
+		return vm.executeBinaryIntegerOperation(op, leftInt, rightInt)
+	}
+
 	leftType := left.Type()
 	rightType := right.Type()
 
 	switch {
 	case leftType == object.INTEGER_OBJ && rightType == object.INTEGER_OBJ:
 		return vm.executeBinaryIntegerOperation(op, left, right)
+	case isNumber(left) && isNumber(right):
+		return vm.executeBinaryFloatOperation(op, left, right)
 	case leftType == object.STRING_OBJ && rightType == object.STRING_OBJ:
 		return vm.executeBinaryStringOperation(op, left, right)
 	default:
@@ -304,12 +525,46 @@ func (vm *VM) executeBinaryIntegerOperation(
 	case code.OpMul:
 		result = leftValue * rightValue
 	case code.OpDiv:
+		if rightValue == 0 {
+			return fmt.Errorf("division by zero")
+		}
 		result = leftValue / rightValue
+	case code.OpMod:
+		if rightValue == 0 {
+			return fmt.Errorf("modulo by zero")
+		}
+		result = leftValue % rightValue
 	default:
 		return fmt.Errorf("unknown integer operator: %d", op)
 	}
 
-	return vm.push(&object.Integer{Value: result})
+	return vm.push(newInteger(result))
+}
+
+func (vm *VM) executeBinaryFloatOperation(
+	op code.Opcode,
+	left, right object.Object,
+) error {
+	leftValue := toFloat(left)
+	rightValue := toFloat(right)
+
+	var result float64
+	switch op {
+	case code.OpAdd:
+		result = leftValue + rightValue
+	case code.OpSub:
+		result = leftValue - rightValue
+	case code.OpMul:
+		result = leftValue * rightValue
+	case code.OpDiv:
+		result = leftValue / rightValue
+	case code.OpMod:
+		result = math.Mod(leftValue, rightValue)
+	default:
+		return fmt.Errorf("unknown float operator: %d", op)
+	}
+
+	return vm.push(&object.Float{Value: result})
 }
 
 func (vm *VM) executeBinaryStringOperation(
@@ -326,13 +581,118 @@ func (vm *VM) executeBinaryStringOperation(
 	return vm.push(&object.String{Value: leftValue + rightValue})
 }
 
+// executeConstOperation adds constant to or subtracts it from the value on
+// top of the stack
+func (vm *VM) executeConstOperation(op code.Opcode, constant object.Object) error {
+	left, leftOk := vm.stack[vm.sp-1].(*object.Integer)
+	right, rightOk := constant.(*object.Integer)
+	if leftOk && rightOk {
+		if op == code.OpAdd {
+			vm.stack[vm.sp-1] = newInteger(left.Value + right.Value)
+		} else {
+			vm.stack[vm.sp-1] = newInteger(left.Value - right.Value)
+		}
+		return nil
+	}
+
+	err := vm.push(constant)
+	if err != nil {
+		return err
+	}
+	return vm.executeBinaryOperation(op)
+}
+
+// executeIncrLocal adds constant to the local in slot of the stack
+func (vm *VM) executeIncrLocal(slot int, constant object.Object) error {
+	left, leftOk := vm.stack[slot].(*object.Integer)
+	right, rightOk := constant.(*object.Integer)
+	if leftOk && rightOk {
+		vm.stack[slot] = newInteger(left.Value + right.Value)
+		return nil
+	}
+
+	err := vm.push(vm.stack[slot])
+	if err != nil {
+		return err
+	}
+	err = vm.executeConstOperation(code.OpAdd, constant)
+	if err != nil {
+		return err
+	}
+	vm.stack[slot] = vm.pop()
+	return nil
+}
+
+// compare pops the top two values of the stack and reports whether they
+// compare with op
+func (vm *VM) compare(op code.Opcode) (bool, error) {
+	left, leftOk := vm.stack[vm.sp-2].(*object.Integer)
+	right, rightOk := vm.stack[vm.sp-1].(*object.Integer)
+	if leftOk && rightOk {
+		result, err := compareIntegers(op, left.Value, right.Value)
+		if err != nil {
+			return false, err
+		}
+		vm.sp -= 2
+		return result, nil
+	}
+
+	err := vm.executeComparison(op)
+	if err != nil {
+		return false, err
+	}
+	return isTruthy(vm.pop()), nil
+}
+
+// compareConst is compare with the constant as its right operand rather
+// than the value on top of the stack
+func (vm *VM) compareConst(op code.Opcode, constant object.Object) (bool, error) {
+	left, leftOk := vm.stack[vm.sp-1].(*object.Integer)
+	right, rightOk := constant.(*object.Integer)
+	if leftOk && rightOk {
+		result, err := compareIntegers(op, left.Value, right.Value)
+		if err != nil {
+			return false, err
+		}
+		vm.sp--
+		return result, nil
+	}
+
+	err := vm.push(constant)
+	if err != nil {
+		return false, err
+	}
+	return vm.compare(op)
+}
+
+func compareIntegers(op code.Opcode, left, right int64) (bool, error) {
+	switch op {
+	case code.OpEqual:
+		return left == right, nil
+	case code.OpNotEqual:
+		return left != right, nil
+	case code.OpGreaterThan:
+		return left > right, nil
+	case code.OpGreaterThanOrEqual:
+		return left >= right, nil
+	default:
+		return false, fmt.Errorf("unknown operator: %d", op)
+	}
+}
+
 func (vm *VM) executeComparison(op code.Opcode) error {
 	right := vm.pop()
 	left := vm.pop()
 
-	if left.Type() == object.INTEGER_OBJ || right.Type() == object.INTEGER_OBJ {
+	if left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ {
 		return vm.executeIntegerComparison(op, left, right)
 	}
+	if isNumber(left) && isNumber(right) {
+		return vm.executeFloatComparison(op, left, right)
+	}
+	if left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ {
+		return vm.executeStringComparison(op, left, right)
+	}
 
 	switch op {
 	case code.OpEqual:
@@ -359,11 +719,52 @@ func (vm *VM) executeIntegerComparison(
 		return vm.push(nativeBoolToBooleanObject(rightValue != leftValue))
 	case code.OpGreaterThan:
 		return vm.push(nativeBoolToBooleanObject(leftValue > rightValue))
+	case code.OpGreaterThanOrEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue >= rightValue))
 	default:
 		return fmt.Errorf("unknown operator: %d", op)
 	}
 }
 
+func (vm *VM) executeFloatComparison(
+	op code.Opcode,
+	left, right object.Object,
+) error {
+	leftValue := toFloat(left)
+	rightValue := toFloat(right)
+
+	switch op {
+	case code.OpEqual:
+		return vm.push(nativeBoolToBooleanObject(rightValue == leftValue))
+	case code.OpNotEqual:
+		return vm.push(nativeBoolToBooleanObject(rightValue != leftValue))
+	case code.OpGreaterThan:
+		return vm.push(nativeBoolToBooleanObject(leftValue > rightValue))
+	case code.OpGreaterThanOrEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue >= rightValue))
+	default:
+		return fmt.Errorf("unknown operator: %d", op)
+	}
+}
+
+func (vm *VM) executeStringComparison(
+	op code.Opcode,
+	left, right object.Object,
+) error {
+	leftValue := left.(*object.String).Value
+	rightValue := right.(*object.String).Value
+
+	switch op {
+	case code.OpEqual:
+		return vm.push(nativeBoolToBooleanObject(rightValue == leftValue))
+	case code.OpNotEqual:
+		return vm.push(nativeBoolToBooleanObject(rightValue != leftValue))
+	default:
+		return fmt.Errorf("unknown operator: %d (%s %s)",
+			op, left.Type(), right.Type())
+	}
+}
+
 func (vm *VM) executeBangOperator() error {
 	operand := vm.pop()
 
@@ -382,12 +783,14 @@ func (vm *VM) executeBangOperator() error {
 func (vm *VM) executeMinusOperator() error {
 	operand := vm.pop()
 
-	if operand.Type() != object.INTEGER_OBJ {
+	switch operand := operand.(type) {
+	case *object.Integer:
+		return vm.push(newInteger(-operand.Value))
+	case *object.Float:
+		return vm.push(&object.Float{Value: -operand.Value})
+	default:
 		return fmt.Errorf("unsupported type for negation: %s", operand.Type())
 	}
-
-	value := operand.(*object.Integer).Value
-	return vm.push(&object.Integer{Value: -value})
 }
 
 func nativeBoolToBooleanObject(input bool) *object.Boolean {
@@ -397,6 +800,17 @@ func nativeBoolToBooleanObject(input bool) *object.Boolean {
 	return False
 }
 
+func isNumber(obj object.Object) bool {
+	return obj.Type() == object.INTEGER_OBJ || obj.Type() == object.FLOAT_OBJ
+}
+
+func toFloat(obj object.Object) float64 {
+	if i, ok := obj.(*object.Integer); ok {
+		return float64(i.Value)
+	}
+	return obj.(*object.Float).Value
+}
+
 func isTruthy(obj object.Object) bool {
 	switch obj := obj.(type) {
 	case *object.Boolean:
@@ -475,6 +889,81 @@ func (vm *VM) executeHashIndex(left, index object.Object) error {
 	return vm.push(pair.Value)
 }
 
+// executeIterNext advances the iterator below the top of the stack, reporting
+// true once it is exhausted
+func (vm *VM) executeIterNext() (bool, error) {
+	array, ok := vm.stack[vm.sp-2].(*object.Array)
+	if !ok {
+		return false, fmt.Errorf("cannot iterate over %s", vm.stack[vm.sp-2].Type())
+	}
+	counter, ok := vm.stack[vm.sp-1].(*object.Integer)
+	if !ok {
+		return false, fmt.Errorf("invalid loop index: %s", vm.stack[vm.sp-1].Type())
+	}
+	index := counter.Value
+	if index < 0 {
+		return false, fmt.Errorf("invalid loop index: %d", index)
+	}
+
+	if index >= int64(len(array.Elements)) {
+		vm.sp -= 2
+		return true, nil
+	}
+
+	vm.stack[vm.sp-1] = newInteger(index + 1)
+	return false, vm.push(array.Elements[index])
+}
+
+func (vm *VM) executeSetIndex(left, index, value object.Object) error {
+	switch left := left.(type) {
+	case *object.Array:
+		i, ok := index.(*object.Integer)
+		if !ok {
+			return fmt.Errorf("array index must be INTEGER. got %s", index.Type())
+		}
+		if i.Value < 0 || i.Value >= int64(len(left.Elements)) {
+			return fmt.Errorf("index %d out of range for array of length %d",
+				i.Value, len(left.Elements))
+		}
+		left.Elements[i.Value] = value
+	case *object.Hash:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return fmt.Errorf("unusable as hash key: %s", index.Type())
+		}
+		left.Pairs[key.HashKey()] = object.HashPair{Key: index, Value: value}
+	default:
+		return fmt.Errorf("index assignment not supported: %s", left.Type())
+	}
+
+	return vm.push(value)
+}
+
+// captureLocal returns the cell for the variable in the stack slot, creating
+// it if no closure has captured the variable yet
+func (vm *VM) captureLocal(slot int) *object.Cell {
+	for _, open := range vm.openCells {
+		if open.slot == slot {
+			return open.cell
+		}
+	}
+
+	cell := &object.Cell{Ref: &vm.stack[slot]}
+	vm.openCells = append(vm.openCells, openCell{slot: slot, cell: cell})
+	return cell
+}
+
+// closeCells closes the cells of the frame starting at basePointer, which is
+// returning. They are the last to have been opened.
+func (vm *VM) closeCells(basePointer int) {
+	i := len(vm.openCells)
+	for i > 0 && vm.openCells[i-1].slot >= basePointer {
+		i--
+		vm.openCells[i].cell.Close()
+	}
+	vm.openCells = vm.openCells[:i]
+}
+
 func (vm *VM) pushClosure(constIndex, numFree int) error {
 	constant := vm.constants[constIndex]
 	function, ok := constant.(*object.CompiledFunction)
@@ -482,9 +971,17 @@ func (vm *VM) pushClosure(constIndex, numFree int) error {
 		return fmt.Errorf("not a function: %+v", constant)
 	}
 
-	free := make([]object.Object, numFree)
+	free := make([]*object.Cell, numFree)
 	for i := 0; i < numFree; i++ {
-		free[i] = vm.stack[vm.sp-numFree+i]
+		switch captured := vm.stack[vm.sp-numFree+i].(type) {
+		case *object.Cell:
+			free[i] = captured
+		default:
+			// a value rather than a variable, such as the current closure
+			cell := &object.Cell{Value: captured}
+			cell.Ref = &cell.Value
+			free[i] = cell
+		}
 	}
 	vm.sp = vm.sp - numFree
 
@@ -513,14 +1010,62 @@ func (vm *VM) callClosure(cl *object.Closure, numArgs int) error {
 		)
 	}
 
-	frame := NewFrame(cl, vm.sp-numArgs)
-	vm.pushFrame(frame)
+	basePointer := vm.sp - numArgs
+	err := vm.growStack(basePointer + cl.Fn.NumLocals)
+	if err != nil {
+		return err
+	}
+	err = vm.pushFrame(cl, basePointer)
+	if err != nil {
+		return err
+	}
 
+	vm.sp = basePointer + cl.Fn.NumLocals
+	vm.clearLocals(basePointer+numArgs, vm.sp)
+
+	return nil
+}
+
+// executeTailCall calls a closure in the current frame, which the caller
+// would otherwise return from as soon as the call returned
+func (vm *VM) executeTailCall(numArgs int) error {
+	cl, ok := vm.stack[vm.sp-1-numArgs].(*object.Closure)
+	if !ok {
+		return vm.executeCall(numArgs)
+	}
+	if numArgs != cl.Fn.NumParameters {
+		return fmt.Errorf(
+			"wrong number of arguments: expected %d, got %d",
+			cl.Fn.NumParameters,
+			numArgs,
+		)
+	}
+
+	frame := vm.currentFrame()
+	err := vm.growStack(frame.basePointer + cl.Fn.NumLocals)
+	if err != nil {
+		return err
+	}
+	vm.closeCells(frame.basePointer)
+
+	// move the callee and its arguments over the caller's
+	copy(vm.stack[frame.basePointer-1:], vm.stack[vm.sp-1-numArgs:vm.sp])
+	frame.cl = cl
+	frame.ip = -1
 	vm.sp = frame.basePointer + cl.Fn.NumLocals
+	vm.clearLocals(frame.basePointer+numArgs, vm.sp)
 
 	return nil
 }
 
+// clearLocals sets the locals in stack slots from up to to Null. Compiled
+// code always sets a local before reading it, but other bytecode may not.
+func (vm *VM) clearLocals(from, to int) {
+	for i := from; i < to; i++ {
+		vm.stack[i] = Null
+	}
+}
+
 func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) error {
 	args := vm.stack[vm.sp-numArgs : vm.sp]
 
@@ -0,0 +1,62 @@
+package vm
+
+import (
+	"testing"
+
+	"github.com/mikeraimondi/monkey/compiler"
+)
+
+var benchmarks = []struct {
+	name  string
+	input string
+}{
+	{
+		"fib",
+		`let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } };
+		fib(20)`,
+	},
+	{
+		"loop",
+		`let sum = fn(n) {
+			let i = 0;
+			let total = 0;
+			while (i < n) {
+				total = total + i;
+				i = i + 1;
+			}
+			total
+		};
+		sum(100000)`,
+	},
+}
+
+func BenchmarkVM(b *testing.B) {
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			benchmarkVM(b, bm.input)
+		})
+		b.Run(bm.name+"-optimized", func(b *testing.B) {
+			benchmarkVM(b, bm.input, compiler.WithOptimization())
+		})
+	}
+}
+
+func benchmarkVM(b *testing.B, input string, options ...compiler.Option) {
+	b.Helper()
+
+	comp := compiler.New(options...)
+	err := comp.Compile(parse(b, input))
+	if err != nil {
+		b.Fatalf("compiler error: %s", err)
+	}
+	bytecode := comp.Bytecode()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		vm := New(bytecode)
+		err := vm.Run()
+		if err != nil {
+			b.Fatalf("vm error: %s", err)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package vm
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/mikeraimondi/monkey/ast"
@@ -21,23 +22,35 @@ func runVmTests(t *testing.T, tests []vmTestCase) {
 
 	for _, tt := range tests {
 		t.Run(tt.input, func(t *testing.T) {
-			program := parse(tt.input)
+			// programs must give the same results when optimized
+			for _, optimize := range []bool{false, true} {
+				program := parse(t, tt.input)
 
-			comp := compiler.New()
-			err := comp.Compile(program)
-			if err != nil {
-				t.Fatalf("compiler error: %s", err)
-			}
+				var options []compiler.Option
+				if optimize {
+					options = append(options, compiler.WithOptimization())
+				}
+				comp := compiler.New(options...)
+				err := comp.Compile(program)
+				if err != nil {
+					t.Fatalf("compiler error (optimize=%t): %s", optimize, err)
+				}
 
-			vm := New(comp.Bytecode())
-			err = vm.Run()
-			if err != nil {
-				t.Fatalf("vm error: %s", err)
-			}
+				err = Verify(comp.Bytecode())
+				if err != nil {
+					t.Fatalf("verifier error (optimize=%t): %s", optimize, err)
+				}
+
+				vm := New(comp.Bytecode())
+				err = vm.Run()
+				if err != nil {
+					t.Fatalf("vm error (optimize=%t): %s", optimize, err)
+				}
 
-			stackElem := vm.LastPoppedStackElem()
+				stackElem := vm.LastPoppedStackElem()
 
-			testExpectedObject(t, tt.expected, stackElem)
+				testExpectedObject(t, tt.expected, stackElem)
+			}
 		})
 	}
 }
@@ -93,6 +106,11 @@ func testExpectedObject(
 		if err != nil {
 			t.Errorf("testIntegerObject failed: %s", err)
 		}
+	case float64:
+		err := testFloatObject(expected, actual)
+		if err != nil {
+			t.Errorf("testFloatObject failed: %s", err)
+		}
 	case bool:
 		err := testBooleanObject(bool(expected), actual)
 		if err != nil {
@@ -120,10 +138,16 @@ func testExpectedObject(
 	}
 }
 
-func parse(input string) *ast.Program {
+func parse(t testing.TB, input string) *ast.Program {
+	t.Helper()
+
 	l := lexer.New(input)
 	p := parser.New(l)
-	return p.ParseProgram()
+	program := p.ParseProgram()
+	if errors := p.Errors(); len(errors) != 0 {
+		t.Fatalf("parser errors for %q: %s", input, strings.Join(errors, "; "))
+	}
+	return program
 }
 
 func testIntegerObject(expected int64, actual object.Object) error {
@@ -139,6 +163,19 @@ func testIntegerObject(expected int64, actual object.Object) error {
 	return nil
 }
 
+func testFloatObject(expected float64, actual object.Object) error {
+	result, ok := actual.(*object.Float)
+	if !ok {
+		return fmt.Errorf("object is not Float. got %T (%+v)", actual, actual)
+	}
+
+	if result.Value != expected {
+		return fmt.Errorf("object has wrong value. got %g. expected %g", result.Value, expected)
+	}
+
+	return nil
+}
+
 func testBooleanObject(expected bool, actual object.Object) error {
 	result, ok := actual.(*object.Boolean)
 	if !ok {
@@ -184,6 +221,30 @@ func TestIntegerArithmetic(t *testing.T) {
 		{"-10", -10},
 		{"-50 + 100 + -50", 0},
 		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
+		{"7 % 3", 1},
+		{"-7 % 3", -1},
+		{"2 + 10 % 4 * 3", 8},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestFloatArithmetic(t *testing.T) {
+	tests := []vmTestCase{
+		{"1.5", 1.5},
+		{"-2.5", -2.5},
+		{"1.5 + 1.5", 3.0},
+		{"1 + 0.5", 1.5},
+		{"0.5 * 4", 2.0},
+		{"7 / 2.0", 3.5},
+		{"7.5 % 2", 1.5},
+		{"2.5e2 - 50", 200.0},
+		{"1.5 < 2", true},
+		{"2 > 1.5", true},
+		{"1 == 1.0", true},
+		{"0.1 + 0.2 != 0.3", true},
+		{"int(3.9) + 1", 4},
+		{"float(1) / 4", 0.25},
 	}
 
 	runVmTests(t, tests)
@@ -222,6 +283,125 @@ func TestBooleanExpressions(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestLogicalOperators(t *testing.T) {
+	tests := []vmTestCase{
+		{"1 <= 1", true},
+		{"2 <= 1", false},
+		{"1 >= 2", false},
+		{"2.5 >= 2", true},
+		{"true && true", true},
+		{"true && false", false},
+		{"false || true", true},
+		{"false || false", false},
+		{"1 < 2 && 2 < 3", true},
+		{"1 && 2", 2},
+		{"false && 2", false},
+		{"1 || 2", 1},
+		{"if (false) { 1 } || 2", 2},
+		{"let x = 1; (x > 0 && x) || 10", 1},
+		{"if (1 > 2 || 2 > 1) { 10 } else { 20 }", 10},
+		{"let f = fn(a, b) { a && b }; f(true, 3)", 3},
+		{"let f = fn(a, b) { if (a) { a || b } }; f(1, 2)", 1},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestLoops(t *testing.T) {
+	tests := []vmTestCase{
+		{"while (false) { 1 }; 5", 5},
+		{"while (true) { break; }; 1", 1},
+		{"for (x in [1, 2, 3]) { }; x", 3},
+		{"for (x in [1, 2, 3]) { if (x == 2) { break; } }; x", 2},
+		{"let f = fn(a) { for (x in a) { if (x > 1) { return x } } }; f([1, 2, 3])", 2},
+		{"let f = fn(a) { for (x in a) { if (x < 3) { continue; } return x; } }; f([1, 2, 3, 4])", 3},
+		{"let f = fn(a) { for (x in a) { x } }; f([1])", Null},
+		{`
+		let f = fn() {
+			for (x in [1, 2]) {
+				for (y in [10, 20, 30]) {
+					if (y == 20) { break; }
+				}
+				if (x == 2) { return x + y; }
+			}
+		};
+		f()`, 22},
+		{"for (x in [1, 2]) { for (y in [3, 4]) { } }; [x, y]", []int{2, 4}},
+		{"let a = []; if (true) { for (x in a) { } }", Null},
+		{"let n = 0; for (x in [1, 2, 3]) { for (y in [4, 5]) { [1, if (y == 5) { break; } else { y }] } n = n + x; }; n", 6},
+		{"let r = 0; while (true) { r = [1, if (true) { break; }, 3]; }; r", 0},
+		{"let s = 0; for (x in [1, 2, 3]) { s = s + x; len(if (true) { continue; }) }; s", 6},
+		{"let i = 0; while (i < 1000) { i = i + 1; len([i, if (true) { continue; }]) }; i", 1000},
+		{`for (x in [1, 2]) { {"a": x, "b": if (x == 1) { continue; } else { x }} }; x`, 2},
+		{"for (x in [1, 2]) { 1 + if (true) { break; } else { 0 } }; x", 1},
+		{"let a = [0]; for (x in [1, 2]) { a[0] = if (x == 2) { break; } else { x } }; a[0]", 1},
+		{"let i = 0; while (if (i == 3) { break; } else { true }) { i = i + 1 }; i", 3},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestIteratingOverNonArray(t *testing.T) {
+	program := parse(t, "for (x in 1) { }")
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm := New(comp.Bytecode())
+	err = vm.Run()
+	if err == nil {
+		t.Fatalf("expected VM error but resulted in none.")
+	}
+	expected := "1:1: cannot iterate over INTEGER"
+	if err.Error() != expected {
+		t.Fatalf("wrong VM error: expected %q, got %q", expected, err)
+	}
+}
+
+func TestAssignment(t *testing.T) {
+	tests := []vmTestCase{
+		{"let x = 1; x = 2", 2},
+		{"let x = 1; x = 2; x", 2},
+		{"let x = 1; let y = 2; x = y = 3; x + y", 6},
+		{"let i = 0; while (i < 5) { i = i + 1 }; i", 5},
+		{"let s = 0; for (x in [1, 2, 3]) { s = s + x }; s", 6},
+		{"let f = fn(x) { x = x * 2; x }; f(3)", 6},
+		{"let a = [1, 2, 3]; a[1] = 5; a[0] + a[1] + a[2]", 9},
+		{"let a = [1, 2]; let b = a; b[0] = 7; a[0]", 7},
+		{`let h = {}; h["k"] = 1; h["k"] = h["k"] + 1; h["k"]`, 2},
+		{"let f = fn() { let n = 0; fn() { n = n + 1 } }; let c = f(); c(); c(); c()", 3},
+		{"let a = []; let i = 0; while (i < 5000) { a = push(a, i); i = i + 1 }; len(a)", 5000},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestAssignmentErrors(t *testing.T) {
+	tests := []vmTestCase{
+		{"let a = [1]; a[5] = 2", "1:19: index 5 out of range for array of length 1"},
+		{`let a = [1]; a["x"] = 2`, "1:21: array index must be INTEGER. got STRING"},
+		{"let s = \"x\"; s[0] = 1", "1:19: index assignment not supported: STRING"},
+	}
+
+	for _, tt := range tests {
+		program := parse(t, tt.input)
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err == nil {
+			t.Fatalf("expected VM error but resulted in none.")
+		}
+		if err.Error() != tt.expected {
+			t.Fatalf("wrong VM error: expected %q, got %q", tt.expected, err)
+		}
+	}
+}
+
 func TestConditionals(t *testing.T) {
 	tests := []vmTestCase{
 		{"if (true) { 10 }", 10},
@@ -254,6 +434,10 @@ func TestStringExpressions(t *testing.T) {
 		{`"monkey"`, "monkey"},
 		{`"mon" + "key"`, "monkey"},
 		{`"mon" + "key" + "banana"`, "monkeybanana"},
+		{`"monkey" == "monkey"`, true},
+		{`"mon" + "key" == "monkey"`, true},
+		{`"monkey" != "banana"`, true},
+		{`let s = "monkey"; s == "banana"`, false},
 	}
 
 	runVmTests(t, tests)
@@ -305,6 +489,9 @@ func TestIndexExpressions(t *testing.T) {
 		{"{1: 1, 2: 2}[2]", 2},
 		{"{1: 1}[0]", Null},
 		{"{}[0]", Null},
+		{"{1: 1}[1.0]", 1},
+		{"{2.0: 2}[2]", 2},
+		{"{0.5: 3}[0.5]", 3},
 	}
 
 	runVmTests(t, tests)
@@ -537,20 +724,125 @@ func TestCallingFunctionsWithWrongArguments(t *testing.T) {
 	tests := []vmTestCase{
 		{
 			input:    `fn() { 1; }(1);`,
-			expected: `wrong number of arguments: expected 0, got 1`,
+			expected: `1:12: wrong number of arguments: expected 0, got 1`,
 		},
 		{
 			input:    `fn(a) { a; }();`,
-			expected: `wrong number of arguments: expected 1, got 0`,
+			expected: `1:13: wrong number of arguments: expected 1, got 0`,
 		},
 		{
 			input:    `fn(a, b) { a + b; }(1);`,
-			expected: `wrong number of arguments: expected 2, got 1`,
+			expected: `1:20: wrong number of arguments: expected 2, got 1`,
+		},
+		{
+			input:    `let f = fn(a) { a; }; fn() { f(); }();`,
+			expected: `1:31: wrong number of arguments: expected 1, got 0`,
 		},
 	}
 
 	for _, tt := range tests {
-		program := parse(tt.input)
+		program := parse(t, tt.input)
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err == nil {
+			t.Fatalf("expected VM error but resulted in none.")
+		}
+		if err.Error() != tt.expected {
+			t.Fatalf("wrong VM error: expected %q, got %q", tt.expected, err)
+		}
+	}
+}
+
+func TestRuntimeErrorStack(t *testing.T) {
+	input := `let div = fn(a, b) { a / b };
+let apply = fn(f) { 1 + f() };
+apply(fn() { div(1, 0) + 1 });`
+
+	comp := compiler.New()
+	err := comp.Compile(parse(t, input))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm := New(comp.Bytecode())
+	err = vm.Run()
+	rtErr, ok := err.(*RuntimeError)
+	if !ok {
+		t.Fatalf("error is not *RuntimeError. got %T (%+v)", err, err)
+	}
+	if rtErr.Err.Error() != "division by zero" {
+		t.Errorf("wrong error. got %q", rtErr.Err)
+	}
+
+	expected := "  at fn div(a, b) (1:24)\n" +
+		"  at fn() (3:17)\n" +
+		"  at fn apply(f) (2:26)\n" +
+		"  at <main> (3:6)\n"
+	if trace := rtErr.StackTrace(); trace != expected {
+		t.Errorf("wrong stack trace.\nexpected %q\ngot %q", expected, trace)
+	}
+}
+
+func TestStackTraceRepeatedFrames(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			"let f = fn(n) { 1 + f(n + 1) };\nf(0)",
+			"  at fn f(n) (1:22)\n" +
+				"  ... 48 more frames of fn f(n)\n" +
+				"  at <main> (2:2)\n",
+		},
+		{
+			// mutual recursion is cut short
+			"let f = 0;\nlet g = fn(n) { 1 + f(n) };\nf = fn(n) { 1 + g(n) };\nf(0)",
+			strings.Repeat("  at fn(n) (3:18)\n  at fn g(n) (2:22)\n", 5) +
+				"  ... 30 more frames\n" +
+				strings.Repeat("  at fn(n) (3:18)\n  at fn g(n) (2:22)\n", 4) +
+				"  at fn(n) (3:18)\n" +
+				"  at <main> (4:2)\n",
+		},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		err := comp.Compile(parse(t, tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode(), WithMaxFrames(50))
+		err = vm.Run()
+		rtErr, ok := err.(*RuntimeError)
+		if !ok {
+			t.Fatalf("error is not *RuntimeError. got %T (%+v)", err, err)
+		}
+		if trace := rtErr.StackTrace(); trace != tt.expected {
+			t.Errorf("wrong stack trace.\nexpected %q\ngot %q", tt.expected, trace)
+		}
+	}
+}
+
+func TestDivisionByZero(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input:    `1 / 0`,
+			expected: `1:3: division by zero`,
+		},
+		{
+			input:    "let f = fn(a) {\n  10 % a\n};\nf(0)",
+			expected: `2:6: modulo by zero`,
+		},
+	}
+
+	for _, tt := range tests {
+		program := parse(t, tt.input)
 		comp := compiler.New()
 		err := comp.Compile(program)
 		if err != nil {
@@ -689,6 +981,214 @@ func TestClosures(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestSharedCapturedVariables(t *testing.T) {
+	tests := []vmTestCase{
+		{"let f = fn() { let n = 0; let inc = fn() { n = n + 1 }; inc(); inc(); n }; f()", 2},
+		{"let make = fn() { let n = 0; [fn() { n = n + 1 }, fn() { n }] }; let p = make(); p[0](); p[0](); p[1]()", 2},
+		{"let make = fn() { let n = 0; fn() { n = n + 1 } }; let a = make(); let b = make(); a(); a(); b()", 1},
+		{"let f = fn() { let n = 0; let g = fn() { fn() { n = n + 10 } }; g()(); n }; f()", 10},
+		{"let f = fn() { let n = 1; let g = fn() { n }; n = 2; g() }; f()", 2},
+		{"let f = fn() { let fact = fn(n) { if (n == 0) { 1 } else { n * fact(n - 1) } }; fact(5) }; f()", 120},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestRecursiveFunctions(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input: `
+			let countDown = fn(x) {
+				if (x == 0) {
+					return 0;
+				} else {
+					countDown(x - 1);
+				}
+			};
+			countDown(1);
+			`,
+			expected: 0,
+		},
+		{
+			input: `
+			let wrapper = fn() {
+				let countDown = fn(x) {
+					if (x == 0) {
+						return 0;
+					} else {
+						countDown(x - 1);
+					}
+				};
+				countDown(1);
+			};
+			wrapper();
+			`,
+			expected: 0,
+		},
+		{
+			input: `
+			let wrapper = fn() {
+				let countDown = fn(x) {
+					if (x == 0) {
+						return 0;
+					} else {
+						let step = fn() { countDown(x - 1) };
+						step();
+					}
+				};
+				countDown(3);
+			};
+			wrapper();
+			`,
+			expected: 0,
+		},
+		{"let x = 1; let f = fn() { let x = x + 1; x }; f()", 2},
+		// global functions call whatever their name refers to when called
+		{"let f = fn(x) { if (x == 0) { 1 } else { f(x - 1) } }; let g = f; f = fn(x) { 100 + x }; g(2)", 101},
+		{"let f = fn() { f = 5; f }; f()", 5},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestTailCalls(t *testing.T) {
+	tests := []vmTestCase{
+		{"let count = fn(n, acc) { if (n == 0) { acc } else { count(n - 1, acc + 1) } }; count(100000, 0)", 100000},
+		{"let count = fn(n) { if (n == 0) { return 0; } return count(n - 1); }; count(100000)", 0},
+		{
+			input: `
+			let sum = fn(arr) {
+				let iter = fn(arr, acc) {
+					if (len(arr) == 0) { acc } else { iter(rest(arr), acc + first(arr)) }
+				};
+				iter(arr, 0)
+			};
+			let arr = [];
+			let i = 0;
+			while (i < 2000) { arr = push(arr, i); i = i + 1; }
+			sum(arr)
+			`,
+			expected: 1999000,
+		},
+		{"let f = fn(arr) { len(arr) }; f([1, 2])", 2},
+		{
+			input: `
+			let collect = fn(n, fs) { if (n == 0) { fs } else { collect(n - 1, push(fs, fn() { n })) } };
+			let fs = collect(3, []);
+			fs[0]() * 10 + fs[2]()
+			`,
+			expected: 31,
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestDeepRecursion(t *testing.T) {
+	tests := []vmTestCase{
+		{"let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(10000)", 10000},
+		{
+			input: `
+			let f = fn() {
+				let n = 0;
+				let inc = fn() { n = n + 1 };
+				let deep = fn(d) { if (d == 0) { inc() } else { 1 + deep(d - 1) } };
+				deep(1000);
+				inc();
+				n
+			};
+			f()
+			`,
+			expected: 2,
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestNumGlobals(t *testing.T) {
+	comp := compiler.New()
+	err := comp.Compile(parse(t, "let a = 1; let b = 2; let c = fn() { a + b }; c()"))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := comp.Bytecode()
+	if n := numGlobals(bytecode); n != 3 {
+		t.Errorf("wrong number of globals. expected 3, got %d", n)
+	}
+
+	bytecode.Instructions = append(bytecode.Instructions, 255)
+	if n := numGlobals(bytecode); n != GlobalsSize {
+		t.Errorf("wrong number of globals for malformed bytecode. expected %d, got %d",
+			GlobalsSize, n)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	input := "let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(100)"
+
+	tests := []struct {
+		options  []Option
+		expected string
+	}{
+		{[]Option{WithMaxFrames(50)}, "1:47: maximum recursion depth exceeded"},
+		{[]Option{WithStackSize(50)}, "1:21: stack overflow"},
+		{[]Option{WithMaxFrames(200), WithStackSize(500)}, ""},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		err := comp.Compile(parse(t, input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode(), tt.options...)
+		err = vm.Run()
+		if tt.expected == "" {
+			if err != nil {
+				t.Errorf("vm error: %s", err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Fatalf("expected VM error but resulted in none.")
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("wrong VM error: expected %q, got %q", tt.expected, err)
+		}
+	}
+}
+
+func TestFunctionInspect(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let fibonacci = fn(x) { x }; fibonacci", "fn fibonacci(x)"},
+		{"fn(a, b) { a }", "fn(a, b)"},
+		{"let outer = fn() { let inner = fn(y) { y }; inner }; outer()", "fn inner(y)"},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		err := comp.Compile(parse(t, tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+
+		if actual := vm.LastPoppedStackElem().Inspect(); actual != tt.expected {
+			t.Errorf("wrong inspection. expected %q, got %q", tt.expected, actual)
+		}
+	}
+}
+
 func TestRecursiveFibonacci(t *testing.T) {
 	tests := []vmTestCase{
 		{
@@ -712,3 +1212,53 @@ func TestRecursiveFibonacci(t *testing.T) {
 
 	runVmTests(t, tests)
 }
+
+func TestSuperinstructions(t *testing.T) {
+	tests := []vmTestCase{
+		{"let f = fn(x) { x + 1 }; f(1)", 2},
+		{"let f = fn(x) { x + 1000 }; f(1000)", 2000},
+		{"let f = fn(x) { x - 1 }; f(-200)", -201},
+		{"let f = fn(x) { x + 1 }; f(1.5)", 2.5},
+		{"let f = fn(x) { x - 1 }; f(0.5)", -0.5},
+		{"let f = fn(x) { x - 1.5 }; f(1)", -0.5},
+		{`let f = fn(x) { x + "!" }; f("hi")`, "hi!"},
+		{"let f = fn(a, b) { if (a > b) { 1 } else { 2 } }; f(2, 1)", 1},
+		{"let f = fn(a, b) { if (a > b) { 1 } else { 2 } }; f(1.5, 2)", 2},
+		{`let f = fn(a) { if (a == "x") { 1 } else { 2 } }; f("x")`, 1},
+		{"let f = fn(a) { if (a != true) { 1 } else { 2 } }; f(true)", 2},
+		{"let f = fn(n) { let i = 0; while (i < n) { i = i + 2; } i }; f(7)", 8},
+		{"let f = fn() { let x = 0.5; x = x + 1; x }; f()", 1.5},
+		{"let f = fn(a, b, c, d, e) { a + b + c + d + e }; f(1, 2, 3, 4, 5)", 15},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestSuperinstructionErrors(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input:    "let f = fn(x) {\n  x + 1\n};\nf(true)",
+			expected: `2:5: unsupported types for binary operation: BOOLEAN INTEGER`,
+		},
+		{
+			input:    "let f = fn(x) {\n  if (x > 1) { 1 }\n};\nf([])",
+			expected: `2:9: unknown operator: 10 (ARRAY INTEGER)`,
+		},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New(compiler.WithOptimization())
+		err := comp.Compile(parse(t, tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err == nil {
+			t.Fatalf("expected VM error but resulted in none.")
+		}
+		if err.Error() != tt.expected {
+			t.Fatalf("wrong VM error: expected %q, got %q", tt.expected, err)
+		}
+	}
+}